@@ -11,7 +11,9 @@ require (
 	github.com/joho/godotenv v1.5.1
 	github.com/kelseyhightower/envconfig v1.4.0
 	github.com/prometheus/client_golang v1.16.0
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/samber/lo v1.38.1
+	github.com/segmentio/kafka-go v0.4.42
 	github.com/tidwall/gjson v1.17.0
 	gitlab.int.tsum.com/core/libraries/corekit.git/healthcheck v0.0.0-20230711153135-4742220a3fa3
 	gitlab.int.tsum.com/core/libraries/corekit.git/kafka v0.0.0-20231026120201-9507ad9e7b19
@@ -23,6 +25,7 @@ require (
 	go.elastic.co/apm/module/apmhttp/v2 v2.4.3
 	go.elastic.co/apm/v2 v2.4.3
 	go.uber.org/zap v1.25.0
+	golang.org/x/time v0.5.0
 	google.golang.org/grpc v1.57.0
 	google.golang.org/protobuf v1.31.0
 )
@@ -57,7 +60,6 @@ require (
 	github.com/prometheus/common v0.44.0 // indirect
 	github.com/prometheus/procfs v0.11.0 // indirect
 	github.com/santhosh-tekuri/jsonschema v1.2.4 // indirect
-	github.com/segmentio/kafka-go v0.4.42 // indirect
 	github.com/spf13/afero v1.9.5 // indirect
 	github.com/spf13/cast v1.5.1 // indirect
 	github.com/spf13/jwalterweatherman v1.1.0 // indirect
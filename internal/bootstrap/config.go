@@ -2,16 +2,19 @@ package bootstrap
 
 // Импорт используемых пакетов
 import (
-	// Импорт библиотеки для работы с Kafka
-	"gitlab.int.tsum.com/core/libraries/corekit.git/kafka/broker"
+	"encoding/json"
+	"fmt"
 	"time" // Пакет для работы с временем
 
 	// Библиотека godotenv для загрузки переменных окружения из файла .env
 	"github.com/joho/godotenv"
-
 	// Псевдоним импорта, который не используется напрямую, но нужен для инициализации пакета
 	_ "github.com/joho/godotenv"
 	"github.com/kelseyhightower/envconfig" // Библиотека для связывания переменных окружения с полями структуры
+	// Импорт библиотеки для работы с Kafka
+	"gitlab.int.tsum.com/core/libraries/corekit.git/kafka/broker"
+
+	"offer-read-service/internal/service/offer_enricher"
 )
 
 // Определение структуры Config для хранения конфигурации приложения
@@ -27,11 +30,118 @@ type Config struct {
 	Elastic          ElasticConfig    `envconfig:"ELASTIC"` // Конфигурация ElasticSearch
 	IndexatorConfig  IndexatorConfig  // Конфигурация индексатора
 	Kafka            KafkaConfig      // Конфигурация Kafka
+	Scheduler        SchedulerConfig  // Конфигурация планировщика индексации
+	Auditor          AuditorConfig    // Конфигурация аудитора согласованности индекса
+	RateLimit        RateLimitConfig  // Ограничение нагрузки индексации и обработки событий на внешние сервисы
+	Health           HealthConfig     // Проверка зависимостей, при недоступности которых обработка приостанавливается
+	Cache            CacheConfig      // Кеширование ответов внешних сервисов при обогащении
+	Upstream         UpstreamsConfig  // Таймауты, повторы и размыкатели запросов к внешним сервисам
 }
 
 // Определение структуры IndexatorConfig
 type IndexatorConfig struct {
-	IndexPerPage int `envconfig:"INDEX_PER_PAGE" default:"50" required:"true"` // Количество индексов на страницу
+	IndexPerPage     int             `envconfig:"INDEX_PER_PAGE" default:"50" required:"true"`                                                                                                                                  // Количество индексов на страницу
+	ReindexSyncLimit int64           `envconfig:"REINDEX_SYNC_LIMIT" default:"500"`                                                                                                                                             // Максимальный размер выборки для синхронной переиндексации
+	RetryAttempts    uint            `envconfig:"INDEX_RETRY_ATTEMPTS" default:"3"`                                                                                                                                             // Количество попыток обработки страницы
+	RetryDelay       time.Duration   `envconfig:"INDEX_RETRY_DELAY" default:"1s"`                                                                                                                                               // Начальная задержка между попытками, растет экспоненциально
+	MaxFailedPages   int             `envconfig:"INDEX_MAX_FAILED_PAGES" default:"5"`                                                                                                                                           // Количество подряд пропущенных страниц, после которого индексация прерывается
+	LeaseTTL         time.Duration   `envconfig:"INDEX_LEASE_TTL" default:"1m"`                                                                                                                                                 // Время жизни блокировки индексации без продления
+	LeaseHeartbeat   time.Duration   `envconfig:"INDEX_LEASE_HEARTBEAT" default:"15s"`                                                                                                                                          // Интервал продления блокировки индексации
+	EnrichTimeout    time.Duration   `envconfig:"ENRICH_TIMEOUT" default:"30s"`                                                                                                                                                 // Общий таймаут запросов к внешним сервисам при обогащении пачки предложений
+	EnrichChunkSize  int             `envconfig:"ENRICH_CHUNK_SIZE" default:"100"`                                                                                                                                              // Максимальное количество кодов в одном запросе к внешнему сервису при обогащении
+	PriceRules       PriceRules      `envconfig:"PRICE_RULES" default:"[{\"name\":\"rub_minimum\",\"currency\":\"RUB\",\"min_units\":1000}]"`                                                                                   // Минимальные цены публикации по валютам (JSON), правило с продавцами применяется только к ним
+	StatusOverrides  StatusOverrides `envconfig:"STATUS_OVERRIDES" default:"[{\"name\":\"iron_watches\",\"attribute\":\"ADDITIONAL_FEATURES\",\"attribute_value\":\"ADDITIONAL_FEATURES_IRON_WATCHES\",\"action\":\"sales\"}]"` // Замена статуса по атрибутам товара и их значениям (JSON): sales, new или exclude
+}
+
+// Тип PriceRules описывает правила минимальной цены публикации, задаваемые в JSON
+type PriceRules []offer_enricher.PriceRule
+
+// Функция Decode разбирает правила минимальной цены из JSON
+func (r *PriceRules) Decode(value string) error {
+	return json.Unmarshal([]byte(value), r)
+}
+
+// Тип StatusOverrides описывает замены статуса по атрибутам товара, задаваемые в JSON; значение атрибута необязательно
+type StatusOverrides []offer_enricher.StatusOverride
+
+// Функция Decode разбирает замены статуса из JSON и проверяет их атрибуты и действия
+func (o *StatusOverrides) Decode(value string) error {
+	if err := json.Unmarshal([]byte(value), o); err != nil {
+		return err
+	}
+	for _, override := range *o {
+		if override.Attribute == "" {
+			return fmt.Errorf("status override %q has no attribute", override.Name)
+		}
+		switch override.Action {
+		case offer_enricher.StatusOverrideSales, offer_enricher.StatusOverrideNew, offer_enricher.StatusOverrideExclude:
+		default:
+			return fmt.Errorf("unknown action %q of status override %q", override.Action, override.Name)
+		}
+	}
+	return nil
+}
+
+// Определение структуры SchedulerConfig для периодической индексации, пустое cron-выражение отключает задачу
+type SchedulerConfig struct {
+	Enabled              bool          `envconfig:"SCHEDULER_ENABLED" default:"false"`                       // Включение планировщика
+	FullIndexCron        string        `envconfig:"SCHEDULER_FULL_INDEX_CRON" default:"0 3 * * *"`           // Расписание полной индексации
+	IncrementalIndexCron string        `envconfig:"SCHEDULER_INCREMENTAL_INDEX_CRON" default:"*/15 * * * *"` // Расписание индексации предложений, созданных после последней индексации
+	Jitter               time.Duration `envconfig:"SCHEDULER_JITTER" default:"1m"`                           // Максимальная случайная задержка запуска
+}
+
+// Определение структуры AuditorConfig для фоновой сверки индекса с внешними сервисами
+type AuditorConfig struct {
+	Enabled    bool          `envconfig:"AUDITOR_ENABLED" default:"false"`   // Включение аудитора
+	Interval   time.Duration `envconfig:"AUDITOR_INTERVAL" default:"1m"`     // Интервал между проверками
+	SampleSize int           `envconfig:"AUDITOR_SAMPLE_SIZE" default:"100"` // Количество предложений в одной проверке
+	Fix        bool          `envconfig:"AUDITOR_FIX" default:"true"`        // Исправление найденных расхождений
+}
+
+// Определение структуры HealthConfig для проверки Elasticsearch и критичных внешних сервисов
+type HealthConfig struct {
+	CheckInterval    time.Duration `envconfig:"HEALTH_CHECK_INTERVAL" default:"5s"`   // Интервал проверки зависимостей
+	FailureThreshold int           `envconfig:"HEALTH_FAILURE_THRESHOLD" default:"3"` // Количество неудачных проверок подряд, после которого обработка приостанавливается
+}
+
+// Определение структуры CacheConfig для кеширования товаров каталога и предложений, сбрасываемого событиями
+type CacheConfig struct {
+	Enabled bool          `envconfig:"CACHE_ENABLED" default:"true"` // Включение кеша
+	TTL     time.Duration `envconfig:"CACHE_TTL" default:"1m"`       // Время жизни записи в кеше
+	Size    int           `envconfig:"CACHE_SIZE" default:"10000"`   // Максимальное количество записей в каждом кеше
+}
+
+// Определение структуры RateLimitConfig для ограничения запросов индексации и обработки событий к внешним сервисам, нулевое значение снимает ограничение
+type RateLimitConfig struct {
+	OfferRate               float64       `envconfig:"RATE_LIMIT_OFFER_RATE" default:"50"`               // Запросов в секунду к сервису предложений
+	OfferConcurrency        int           `envconfig:"RATE_LIMIT_OFFER_CONCURRENCY" default:"4"`         // Одновременных запросов к сервису предложений
+	StockRate               float64       `envconfig:"RATE_LIMIT_STOCK_RATE" default:"50"`               // Запросов в секунду к сервису запасов
+	StockConcurrency        int           `envconfig:"RATE_LIMIT_STOCK_CONCURRENCY" default:"4"`         // Одновременных запросов к сервису запасов
+	CatalogReadRate         float64       `envconfig:"RATE_LIMIT_CATALOG_READ_RATE" default:"50"`        // Запросов в секунду к сервису чтения каталога
+	CatalogReadConcurrency  int           `envconfig:"RATE_LIMIT_CATALOG_READ_CONCURRENCY" default:"4"`  // Одновременных запросов к сервису чтения каталога
+	CatalogWriteRate        float64       `envconfig:"RATE_LIMIT_CATALOG_WRITE_RATE" default:"50"`       // Запросов в секунду к сервису записи каталога
+	CatalogWriteConcurrency int           `envconfig:"RATE_LIMIT_CATALOG_WRITE_CONCURRENCY" default:"4"` // Одновременных запросов к сервису записи каталога
+	LatencyThreshold        time.Duration `envconfig:"RATE_LIMIT_LATENCY_THRESHOLD" default:"500ms"`     // Средняя задержка ответа, после которой индексация замедляется
+	ErrorRateThreshold      float64       `envconfig:"RATE_LIMIT_ERROR_RATE_THRESHOLD" default:"0.1"`    // Доля ошибок, после которой индексация замедляется
+}
+
+// Определение структуры UpstreamsConfig для настройки запросов к каждому внешнему сервису,
+// переменные окружения имеют вид UPSTREAM_<СЕРВИС>_<НАСТРОЙКА>, например UPSTREAM_STOCK_TIMEOUT
+type UpstreamsConfig struct {
+	Offer        UpstreamConfig `envconfig:"OFFER"`         // Сервис предложений
+	CatalogRead  UpstreamConfig `envconfig:"CATALOG_READ"`  // Сервис чтения каталога
+	CatalogWrite UpstreamConfig `envconfig:"CATALOG_WRITE"` // Сервис записи каталога
+	Stock        UpstreamConfig `envconfig:"STOCK"`         // Сервис запасов
+}
+
+// Определение структуры UpstreamConfig для запросов к внешнему сервису, нулевое значение отключает настройку
+type UpstreamConfig struct {
+	Timeout            time.Duration `split_words:"true" default:"10s"`   // Таймаут запроса со всеми попытками
+	AttemptTimeout     time.Duration `split_words:"true" default:"3s"`    // Таймаут одной попытки
+	Retries            uint          `split_words:"true" default:"2"`     // Количество повторов при временной недоступности сервиса
+	RetryBackoff       time.Duration `split_words:"true" default:"100ms"` // Начальная задержка между повторами, растет экспоненциально
+	BreakerFailures    int           `split_words:"true" default:"5"`     // Количество неудачных запросов подряд, после которого запросы не отправляются
+	BreakerOpenTimeout time.Duration `split_words:"true" default:"30s"`   // Время, через которое к сервису отправляется пробный запрос
 }
 
 // Определение структуры GRPCServerConfig для конфигурации gRPC сервера
@@ -71,16 +181,41 @@ type ElasticAPM struct {
 
 // Определение структуры ElasticConfig для конфигурации ElasticSearch
 type ElasticConfig struct {
-	Addresses      []string `envconfig:"ADDRESSES" required:"true"`                                    // Адреса ElasticSearch
-	OfferIndexName string   `envconfig:"OFFER_INDEX_NAME" default:"delta.offer_index" required:"true"` // Название индекса предложений
+	Addresses           []string `envconfig:"ADDRESSES" required:"true"`                                                      // Адреса ElasticSearch
+	OfferIndexName      string   `envconfig:"OFFER_INDEX_NAME" default:"delta.offer_index" required:"true"`                   // Название индекса предложений
+	DeadLetterIndexName string   `envconfig:"DEAD_LETTER_INDEX_NAME" default:"delta.offer_index_dead_letter" required:"true"` // Название индекса предложений, которые не удалось проиндексировать
+	LeaseIndexName      string   `envconfig:"LEASE_INDEX_NAME" default:"delta.offer_index_leases" required:"true"`            // Название индекса блокировок индексации
+	OutboxIndexName     string   `envconfig:"OUTBOX_INDEX_NAME" default:"delta.offer_index_outbox" required:"true"`           // Название индекса неопубликованных событий изменения статуса
+	RecheckIndexName    string   `envconfig:"RECHECK_INDEX_NAME" default:"delta.offer_index_rechecks" required:"true"`        // Название индекса запланированных повторных проверок предложений
 }
 
 // Определение структуры KafkaConfig для конфигурации Kafka
 type KafkaConfig struct {
-	broker.Config          `envconfig:"KAFKA"` // Настройки брокера Kafka
-	Enabled                bool                `envconfig:"KAFKA_ENABLED" default:"true"`              // Включение Kafka
-	ConsumerGroupId        string              `envconfig:"CONSUMER_GROUP_ID" required:"true"`         // ID группы потребителей
-	StockUnitReservedTopic string              `envconfig:"STOCK_UNIT_RESERVED_TOPIC" required:"true"` // Тема для зарезервированных единиц запаса
+	broker.Config                  `envconfig:"KAFKA"` // Настройки брокера Kafka
+	Enabled                        bool                `envconfig:"KAFKA_ENABLED" default:"true"`              // Включение Kafka
+	ConsumerGroupId                string              `envconfig:"CONSUMER_GROUP_ID" required:"true"`         // ID группы потребителей
+	StockUnitReservedTopic         string              `envconfig:"STOCK_UNIT_RESERVED_TOPIC" required:"true"` // Тема для зарезервированных единиц запаса
+	StockUnitSoldTopic             string              `envconfig:"STOCK_UNIT_SOLD_TOPIC"`                     // Тема для проданных единиц запаса, пустое значение отключает потребителя
+	StockUnitReleasedTopic         string              `envconfig:"STOCK_UNIT_RELEASED_TOPIC"`                 // Тема для снятых с резерва единиц запаса
+	StockUnitReturnedToSellerTopic string              `envconfig:"STOCK_UNIT_RETURNED_TO_SELLER_TOPIC"`       // Тема для единиц запаса, возвращенных продавцу
+	OfferPriceChangedTopic         string              `envconfig:"OFFER_PRICE_CHANGED_TOPIC"`                 // Тема для изменений цены предложений
+	ItemPublicationChangedTopic    string              `envconfig:"ITEM_PUBLICATION_CHANGED_TOPIC"`            // Тема для изменений флагов публикации товаров каталога
+	RecheckDelay                   time.Duration       `envconfig:"KAFKA_RECHECK_DELAY" default:"5s"`          // Задержка повторной проверки предложения, статус которого еще не отразил событие
+	RecheckAttempts                int                 `envconfig:"KAFKA_RECHECK_ATTEMPTS" default:"5"`        // Количество попыток обновления, пока статус не отразит событие
+	BatchWindow                    time.Duration       `envconfig:"KAFKA_BATCH_WINDOW" default:"200ms"`        // Время накопления предложений из последовательных событий перед пакетным обновлением, 0 - обновление до подтверждения события
+	BatchSize                      int                 `envconfig:"KAFKA_BATCH_SIZE" default:"100"`            // Максимальное количество предложений в пакете
+	DeadLetterTopic                string              `envconfig:"KAFKA_DEAD_LETTER_TOPIC"`                   // Топик для событий, обработка которых не удалась после всех попыток, пустое значение отключает публикацию
+	RetryAttempts                  uint                `envconfig:"KAFKA_RETRY_ATTEMPTS" default:"10"`         // Количество попыток обработки события
+	RetryDelay                     time.Duration       `envconfig:"KAFKA_RETRY_DELAY" default:"100ms"`         // Начальная задержка между попытками, растет экспоненциально
+	RetryMaxDelay                  time.Duration       `envconfig:"KAFKA_RETRY_MAX_DELAY" default:"10s"`       // Максимальная задержка между попытками
+	RetryMaxJitter                 time.Duration       `envconfig:"KAFKA_RETRY_MAX_JITTER" default:"100ms"`    // Максимальная случайная добавка к задержке
+	RetryMaxElapsed                time.Duration       `envconfig:"KAFKA_RETRY_MAX_ELAPSED" default:"1m"`      // Максимальное время обработки события со всеми попытками, после него событие уходит в топик недоставленных
+	LagCheckInterval               time.Duration       `envconfig:"KAFKA_LAG_CHECK_INTERVAL" default:"30s"`    // Интервал проверки отставания потребителей
+	LagThreshold                   int64               `envconfig:"KAFKA_LAG_THRESHOLD" default:"10000"`       // Отставание партиции, после которого потребитель считается отстающим, 0 отключает проверку
+	LagReadiness                   bool                `envconfig:"KAFKA_LAG_READINESS" default:"false"`       // Снимать готовность, пока отставание партиции превышает порог
+	OfferStatusChangedTopic        string              `envconfig:"KAFKA_OFFER_STATUS_CHANGED_TOPIC"`          // Топик для событий изменения статуса предложений, пустое значение отключает публикацию
+	OutboxRelayInterval            time.Duration       `envconfig:"KAFKA_OUTBOX_RELAY_INTERVAL" default:"1s"`  // Интервал проверки неопубликованных событий изменения статуса
+	OutboxBatchSize                int                 `envconfig:"KAFKA_OUTBOX_BATCH_SIZE" default:"100"`     // Количество событий, публикуемых за раз
 }
 
 // Функция NewConfig создает и возвращает новую конфигурацию
@@ -0,0 +1,136 @@
+package bootstrap
+
+import (
+	"context"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/samber/lo"
+	"gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/catalog"
+	"gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/offer"
+	"gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/stock"
+	"gitlab.int.tsum.com/preowned/simona/delta/core.git/retrying_consumer"
+
+	"offer-read-service/internal/consumer"
+	"offer-read-service/internal/model"
+)
+
+// Функция initConsumers подписывает обработчики на топики событий, затрагивающих предложения.
+// Топики без настроенного имени пропускаются.
+func (r *Root) initConsumers(ctx context.Context) {
+	registry := consumerRegistry{
+		deadLetters:        consumer.NewDeadLetterWriter(r.Config.Kafka.Config, r.Config.Kafka.DeadLetterTopic),
+		deadLetterHandlers: map[string]consumer.ReplayFunc{},
+		topicHandlers:      map[string]consumer.ReplayFunc{},
+	}
+	// Без топика недоставленных событий события после всех попыток только логируются
+	if registry.deadLetters != nil {
+		r.RegisterStopHandler(func() { _ = registry.deadLetters.Close() })
+		r.deadLetterReplay = func(ctx context.Context) (consumer.ReplayResult, error) {
+			return consumer.ReplayDeadLetters(ctx, r.Config.Kafka.Config, r.Config.Kafka.ConsumerGroupId+"-dead-letter-replay", registry.deadLetters, registry.deadLetterHandlers)
+		}
+	}
+	r.topicReplay = consumer.NewTopicReplay(r.Config.Kafka.Config, registry.topicHandlers)
+
+	// Статус зарезервированного предложения перепроверяется, пока внешние сервисы не обработают событие
+	registerOfferEventConsumer(ctx, r, r.Config.Kafka.StockUnitReservedTopic, consumer.StockUnitReserved,
+		func(event stock.StockUnitReservedEvent) string { return event.OfferCode },
+		consumer.StatusIn(model.OfferStatusCodeInOrder, model.OfferStatusCodeSold), registry)
+	registerOfferEventConsumer(ctx, r, r.Config.Kafka.StockUnitSoldTopic, consumer.StockUnitSold,
+		func(event stock.StockUnitSoldEvent) string { return event.OfferCode },
+		consumer.StatusIn(model.OfferStatusCodeSold), registry)
+	registerOfferEventConsumer(ctx, r, r.Config.Kafka.StockUnitReleasedTopic, consumer.StockUnitReleased,
+		func(event stock.StockUnitReleasedEvent) string { return event.OfferCode },
+		consumer.StatusNotIn(model.OfferStatusCodeInOrder), registry)
+	// События, меняющие данные предложения или товара, сбрасывают их в кеше до обновления предложений
+	registerOfferEventConsumer(ctx, r, r.Config.Kafka.StockUnitReturnedToSellerTopic,
+		consumer.Invalidating(consumer.StockUnitReturnedToSeller, func(event stock.StockUnitReturnedToSellerEvent) {
+			r.offersCache.Invalidate(event.OfferCode)
+		}),
+		func(event stock.StockUnitReturnedToSellerEvent) string { return event.OfferCode },
+		consumer.StatusIn(model.OfferStatusCodeReturnedToSeller), registry)
+	registerOfferEventConsumer(ctx, r, r.Config.Kafka.OfferPriceChangedTopic,
+		consumer.Invalidating(consumer.OfferPriceChanged, func(event offer.OfferPriceChangedEvent) {
+			r.offersCache.Invalidate(event.OfferCode)
+		}),
+		func(event offer.OfferPriceChangedEvent) string { return event.OfferCode },
+		nil, registry)
+	registerOfferEventConsumer(ctx, r, r.Config.Kafka.ItemPublicationChangedTopic,
+		consumer.Invalidating(consumer.ItemPublicationFlagsChanged(r.Repositories.OfferRepository), func(event catalog.ItemPublicationFlagsChangedEvent) {
+			r.catalogItemsCache.Invalidate(event.ItemCode)
+		}),
+		func(event catalog.ItemPublicationFlagsChangedEvent) string { return event.ItemCode },
+		nil, registry)
+
+	// Отставание считается по всем топикам с подписанными обработчиками
+	r.lagMonitor = consumer.NewLagMonitor(r.Config.Kafka.Config, r.Config.Kafka.ConsumerGroupId, lo.Keys(registry.topicHandlers), r.Config.Kafka.LagThreshold)
+	r.RegisterBackgroundJob(func() error {
+		r.lagMonitor.Run(ctxzap.ToContext(ctx, r.Logger.Named("consumer_lag")), r.Config.Kafka.LagCheckInterval)
+		return nil
+	})
+}
+
+// Структура consumerRegistry собирает обработчики подписанных топиков для повторной обработки событий
+type consumerRegistry struct {
+	// Пустой, если топик недоставленных событий не настроен
+	deadLetters *consumer.DeadLetterWriter
+	// Обработчики событий из топика недоставленных событий (JSON), по исходному топику
+	deadLetterHandlers map[string]consumer.ReplayFunc
+	// Обработчики сообщений исходных топиков для повторного чтения топика с заданной позиции
+	topicHandlers map[string]consumer.ReplayFunc
+}
+
+// Функция registerOfferEventConsumer запускает потребителя топика с пакетной обработкой затронутых предложений
+// и повторной проверкой предложений, статус которых еще не отразил событие.
+// Недоставленные события публикуются с ключом eventKey, чтобы события одного предложения сохраняли порядок
+func registerOfferEventConsumer[T any](
+	ctx context.Context,
+	r *Root,
+	topic string,
+	offerCodes consumer.OfferCodesFunc[T],
+	eventKey func(event T) string,
+	settled consumer.SettledFunc,
+	registry consumerRegistry,
+) {
+	if topic == "" {
+		return
+	}
+	refresh := consumer.RefreshOffers(r.indexingOfferClient(), r.Services.OfferEnricher, r.Repositories.OfferRepository, settled)
+	queue := consumer.NewDelayQueue(topic, r.Config.Kafka.RecheckDelay, r.Config.Kafka.RecheckAttempts, r.Config.Kafka.BatchSize, r.Repositories.RecheckRepository)
+	batcher := consumer.NewBatcher(topic, r.Config.Kafka.BatchWindow, r.Config.Kafka.BatchSize, queue)
+	logger := r.Logger.Named(topic)
+	r.RegisterBackgroundJob(func() error {
+		queue.Run(ctxzap.ToContext(ctx, logger.Named("recheck")), refresh)
+		return nil
+	})
+	r.RegisterBackgroundJob(func() error {
+		batcher.Run(ctxzap.ToContext(ctx, logger), refresh)
+		return nil
+	})
+
+	handler := consumer.OfferEvent(batcher, offerCodes, r.Repositories.OfferRepository)
+	retryingHandler := consumer.RetryingMessageHandler(handler, r.Config.Kafka.retryPolicy(), r.dependencies, registry.deadLetters, eventKey, topic)
+	registry.deadLetterHandlers[topic] = consumer.Replay(handler)
+	registry.topicHandlers[topic] = consumer.ReplayTopic(retryingHandler)
+	r.RegisterBackgroundJob(func() error {
+		retrying_consumer.NewConsumer[T](
+			r.Config.Kafka.ConsumerGroupId,
+			topic,
+			r.Infrastructure.KafkaConsumer,
+			retryingHandler,
+			r.Tracer,
+			r.Logger,
+		).Run(ctx)
+		return nil
+	})
+}
+
+// Функция retryPolicy собирает политику повторных попыток обработки событий
+func (c KafkaConfig) retryPolicy() consumer.RetryPolicy {
+	return consumer.RetryPolicy{
+		Attempts:   c.RetryAttempts,
+		Delay:      c.RetryDelay,
+		MaxDelay:   c.RetryMaxDelay,
+		MaxJitter:  c.RetryMaxJitter,
+		MaxElapsed: c.RetryMaxElapsed,
+	}
+}
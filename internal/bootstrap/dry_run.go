@@ -0,0 +1,116 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"gitlab.int.tsum.com/preowned/simona/delta/core.git/custom_error"
+	"go.elastic.co/apm/v2"
+	"go.uber.org/zap"
+
+	"offer-read-service/internal/service"
+)
+
+// Определение структуры dryRunStore, хранящей последний отчет пробной индексации.
+// Отчет хранится в памяти пода, который выполнил пробную индексацию: он теряется при рестарте,
+// а /dry_run/report на других репликах его не отдает, поэтому отчет нужно забирать с того же пода
+type dryRunStore struct {
+	mu      sync.Mutex
+	running bool
+	report  *service.DryRunReport
+	err     error
+}
+
+// Метод start отмечает начало пробной индексации, возвращает false, если она уже запущена
+func (s *dryRunStore) start() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		return false
+	}
+	s.running = true
+	return true
+}
+
+// Метод finish сохраняет результат пробной индексации
+func (s *dryRunStore) finish(report service.DryRunReport, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running = false
+	s.err = err
+	if err == nil {
+		s.report = &report
+	}
+}
+
+// Функция dryRunHandler запускает в фоне пробную индексацию без записи в индекс.
+// Тело запроса - необязательная выборка как у /reindex, без нее пересчитываются все предложения.
+func dryRunHandler(indexator service.Indexator, store *dryRunStore) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPost {
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		var scope service.ReindexScope
+		if err := json.NewDecoder(request.Body).Decode(&scope); err != nil && !errors.Is(err, io.EOF) {
+			writeError(writer, &custom_error.InvalidArgument{Message: fmt.Sprintf("can't decode dry run scope: %s", err)})
+			return
+		}
+
+		if !store.start() {
+			writeJSON(writer, http.StatusConflict, map[string]string{"error": "dry run is already started"})
+			return
+		}
+
+		go func() {
+			ctx := ctxzap.ToContext(apm.DetachedContext(request.Context()), ctxzap.Extract(request.Context()).Named("dry_run"))
+
+			ctxzap.Info(ctx, "dry run is starting", zap.Any("scope", scope))
+			report, err := indexator.DryRun(ctx, scope)
+			store.finish(report, err)
+			if err != nil {
+				ctxzap.Error(ctx, "couldn't dry run", zap.Error(err))
+				return
+			}
+			ctxzap.Info(ctx, "dry run finished", zap.Int("num_computed", report.NumComputed), zap.Int("num_changed", report.NumChanged))
+		}()
+
+		writer.WriteHeader(http.StatusAccepted)
+	})
+}
+
+// Функция dryRunReportHandler отдает последний отчет пробной индексации в формате JSON или CSV (?format=csv)
+func dryRunReportHandler(store *dryRunStore) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		store.mu.Lock()
+		running, report, err := store.running, store.report, store.err
+		store.mu.Unlock()
+
+		switch {
+		case report == nil && running:
+			writeJSON(writer, http.StatusAccepted, map[string]bool{"running": true})
+			return
+		case report == nil && err != nil:
+			writeError(writer, err)
+			return
+		case report == nil:
+			writeJSON(writer, http.StatusNotFound, map[string]string{"error": "dry run report not found"})
+			return
+		}
+
+		if request.URL.Query().Get("format") == "csv" {
+			writer.Header().Set("Content-Type", "text/csv")
+			writer.Header().Set("Content-Disposition", `attachment; filename="dry_run.csv"`)
+			_ = report.WriteCSV(writer)
+			return
+		}
+		writer.Header().Set("Content-Disposition", `attachment; filename="dry_run.json"`)
+		writeJSON(writer, http.StatusOK, report)
+	})
+}
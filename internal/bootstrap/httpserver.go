@@ -4,9 +4,13 @@ package bootstrap
 import (
 	// Стандартные пакеты и пакеты для работы с HTTP
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/pprof"
+	"strconv"
+	"sync/atomic"
 
 	// Библиотеки для логирования, мониторинга и трассировки
 	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
@@ -14,10 +18,16 @@ import (
 	"github.com/samber/lo"
 	"gitlab.int.tsum.com/core/libraries/corekit.git/healthcheck"
 	"gitlab.int.tsum.com/core/libraries/corekit.git/observability/tracing"
+	"gitlab.int.tsum.com/preowned/simona/delta/core.git/custom_error"
 	"gitlab.int.tsum.com/preowned/simona/delta/core.git/log_key"
+	"go.elastic.co/apm/module/apmhttp/v2"
+	"go.elastic.co/apm/v2"
 	"go.uber.org/zap"
 
 	// Локальные пакеты
+	"offer-read-service/internal/consumer"
+	"offer-read-service/internal/health"
+	"offer-read-service/internal/repository"
 	"offer-read-service/internal/service"
 )
 
@@ -39,8 +49,31 @@ func (r *Root) initHTTPServer() {
 		healthcheck.WithReleaseID(r.Config.ReleaseID),
 	))
 
+	// Состояние зависимостей, приостановки обработки и отставания потребителей Kafka
+	mux.Handle("/health/dependencies", dependenciesHandler(r.dependencies, r.lagMonitor))
+
+	// Эндпоинт готовности, сервис не готов, пока недоступен Elasticsearch, из которого он отдает предложения,
+	// и, если включено KAFKA_LAG_READINESS, пока отставание потребителей Kafka превышает порог
+	mux.Handle("/ready", readinessHandler(r.dependencies, lo.Ternary(r.Config.Kafka.LagReadiness, r.lagMonitor, nil)))
+
 	// Дополнительный обработчик HTTP
 	mux.Handle("/full_index", r.defaultHTTPHandler(fullIndexHandler(r.Services.Indexator)))
+	mux.Handle("/reindex", r.defaultHTTPHandler(reindexHandler(r.Services.Indexator, r.Config.IndexatorConfig.ReindexSyncLimit)))
+	mux.Handle("/dead_letter", r.defaultHTTPHandler(deadLetterListHandler(r.Repositories.DeadLetterRepository)))
+	mux.Handle("/dead_letter/retry", r.defaultHTTPHandler(deadLetterRetryHandler(r.Services.Indexator)))
+	if r.deadLetterReplay != nil {
+		mux.Handle("/kafka_dead_letter/replay", r.defaultHTTPHandler(kafkaDeadLetterReplayHandler(r.deadLetterReplay)))
+	}
+	mux.Handle("/kafka_replay", r.defaultHTTPHandler(kafkaTopicReplayHandler(r.topicReplay)))
+	mux.Handle("/indexing_lock", r.defaultHTTPHandler(indexingLockHandler(r.Services.Indexator)))
+	dryRuns := &dryRunStore{}
+	mux.Handle("/dry_run", r.defaultHTTPHandler(dryRunHandler(r.Services.Indexator, dryRuns)))
+	mux.Handle("/dry_run/report", r.defaultHTTPHandler(dryRunReportHandler(dryRuns)))
+	mux.Handle("/rate_limits", r.defaultHTTPHandler(rateLimitHandler(r.rateLimiters)))
+	mux.Handle("/status_overrides", r.defaultHTTPHandler(statusOverridesHandler(r.Config.IndexatorConfig.StatusOverrides)))
+	if r.scheduler != nil {
+		mux.Handle("/scheduler", r.defaultHTTPHandler(schedulerStatusHandler(r.scheduler)))
+	}
 
 	// Настройка HTTP сервера
 	r.Infrastructure.HTTP = &http.Server{
@@ -95,6 +128,248 @@ func fullIndexHandler(indexator service.Indexator) http.Handler {
 	})
 }
 
+// Функция reindexHandler переиндексирует предложения из заданной выборки (продавцы, коды предложений, коды товаров, статусы).
+// Небольшие выборки обрабатываются синхронно с возвратом результата по каждому предложению, остальные - в фоне.
+func reindexHandler(indexator service.Indexator, syncLimit int64) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPost {
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		var scope service.ReindexScope
+		if err := json.NewDecoder(request.Body).Decode(&scope); err != nil {
+			writeError(writer, &custom_error.InvalidArgument{Message: fmt.Sprintf("can't decode reindex scope: %s", err)})
+			return
+		}
+
+		size, result, err := reindex(request.Context(), indexator, syncLimit, scope)
+		if err != nil {
+			writeError(writer, err)
+			return
+		}
+		if result != nil {
+			writeJSON(writer, http.StatusOK, result)
+			return
+		}
+		writeJSON(writer, http.StatusAccepted, map[string]int64{"scope_size": size})
+	})
+}
+
+// Функция reindex переиндексирует выборку не больше syncLimit синхронно и возвращает результат,
+// большую выборку запускает в фоне, как и полную индексацию, и возвращает только ее размер
+func reindex(ctx context.Context, indexator service.Indexator, syncLimit int64, scope service.ReindexScope) (int64, *service.ReindexResult, error) {
+	size, err := indexator.ScopeSize(ctx, scope)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if size <= syncLimit {
+		result, err := indexator.Reindex(ctx, scope)
+		if err != nil {
+			return 0, nil, err
+		}
+		return size, &result, nil
+	}
+
+	go func() {
+		ctx := ctxzap.ToContext(apm.DetachedContext(ctx), ctxzap.Extract(ctx).Named("reindex"))
+
+		ctxzap.Info(ctx, "reindex is starting", zap.Any("scope", scope), zap.Int64("size", size))
+		result, err := indexator.Reindex(ctx, scope)
+		if err != nil {
+			ctxzap.Error(ctx, "couldn't reindex", zap.Error(err))
+			return
+		}
+		ctxzap.Info(ctx, "reindex finished", zap.Int("num_indexed", result.NumIndexed), zap.Duration("elapsed", result.Elapsed))
+	}()
+	return size, nil, nil
+}
+
+// Функция deadLetterListHandler отдает список предложений, которые не удалось проиндексировать (?limit=N)
+func deadLetterListHandler(deadLetterRepository repository.DeadLetterRepository) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		limit := 100
+		if value := request.URL.Query().Get("limit"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed <= 0 {
+				writeError(writer, &custom_error.InvalidArgument{Message: fmt.Sprintf("invalid limit '%s'", value)})
+				return
+			}
+			limit = parsed
+		}
+
+		deadLetters, err := deadLetterRepository.List(request.Context(), limit)
+		if err != nil {
+			writeError(writer, err)
+			return
+		}
+		writeJSON(writer, http.StatusOK, deadLetters)
+	})
+}
+
+// Функция deadLetterRetryHandler запускает в фоне повторную индексацию предложений из списка неудачных
+func deadLetterRetryHandler(indexator service.Indexator) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPost {
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		go func() {
+			ctx := ctxzap.ToContext(apm.DetachedContext(request.Context()), ctxzap.Extract(request.Context()).Named("dead_letter_retry"))
+
+			ctxzap.Info(ctx, "dead letter retry is starting")
+			result, err := indexator.RetryDeadLetters(ctx)
+			if err != nil {
+				ctxzap.Error(ctx, "couldn't retry dead letters", zap.Error(err))
+				return
+			}
+			ctxzap.Info(ctx, "dead letter retry finished", zap.Int("num_indexed", result.NumIndexed), zap.Int("num_failed", result.NumFailed))
+		}()
+
+		writer.WriteHeader(http.StatusAccepted)
+	})
+}
+
+// Функция kafkaDeadLetterReplayHandler запускает в фоне повторную обработку топика недоставленных событий
+func kafkaDeadLetterReplayHandler(replay func(ctx context.Context) (consumer.ReplayResult, error)) http.Handler {
+	var running atomic.Bool
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPost {
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if !running.CompareAndSwap(false, true) {
+			writeJSON(writer, http.StatusConflict, map[string]string{"error": "dead letter replay is already running"})
+			return
+		}
+
+		go func() {
+			defer running.Store(false)
+			ctx := ctxzap.ToContext(apm.DetachedContext(request.Context()), ctxzap.Extract(request.Context()).Named("kafka_dead_letter_replay"))
+
+			ctxzap.Info(ctx, "dead letter replay is starting")
+			result, err := replay(ctx)
+			if err != nil {
+				ctxzap.Error(ctx, "couldn't replay dead letters", zap.Error(err), zap.Int("num_replayed", result.NumReplayed), zap.Int("num_failed", result.NumFailed))
+				return
+			}
+			ctxzap.Info(ctx, "dead letter replay finished", zap.Int("num_replayed", result.NumReplayed), zap.Int("num_failed", result.NumFailed))
+		}()
+
+		writer.WriteHeader(http.StatusAccepted)
+	})
+}
+
+// Функция kafkaTopicReplayHandler запускает в фоне повторное чтение топика событий с заданного смещения или времени (POST)
+// и отдает ход текущего или последнего повторного чтения (GET)
+func kafkaTopicReplayHandler(topicReplay *consumer.TopicReplay) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch request.Method {
+		case http.MethodGet:
+			writeJSON(writer, http.StatusOK, topicReplay.Progress())
+		case http.MethodPost:
+			var replayRequest consumer.TopicReplayRequest
+			if err := json.NewDecoder(request.Body).Decode(&replayRequest); err != nil {
+				writeError(writer, &custom_error.InvalidArgument{Message: fmt.Sprintf("can't decode replay request: %s", err)})
+				return
+			}
+
+			ctx := ctxzap.ToContext(apm.DetachedContext(request.Context()), ctxzap.Extract(request.Context()).Named("kafka_replay"))
+			progress, err := topicReplay.Start(ctx, replayRequest)
+			if err != nil {
+				writeError(writer, err)
+				return
+			}
+			ctxzap.Info(ctx, "topic replay is starting", zap.Any("request", replayRequest))
+			writeJSON(writer, http.StatusAccepted, progress)
+		default:
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// Функция indexingLockHandler отдает текущего владельца кластерной блокировки индексации
+func indexingLockHandler(indexator service.Indexator) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		lease, err := indexator.LockHolder(request.Context())
+		if err != nil {
+			writeError(writer, err)
+			return
+		}
+		if lease == nil {
+			writeJSON(writer, http.StatusOK, map[string]bool{"locked": false})
+			return
+		}
+		writeJSON(writer, http.StatusOK, lease)
+	})
+}
+
+// Функция readinessHandler отдает 503, пока недоступен Elasticsearch или отставание потребителей превышает порог.
+// Недоступность внешних сервисов не мешает отдавать предложения и на готовность не влияет,
+// отставание учитывается, только если передан lagMonitor
+func readinessHandler(dependencies *health.Monitor, lagMonitor *consumer.LagMonitor) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if err := errors.Join(dependencies.HealthyOf(elasticsearchDependency), lagMonitor.Ready()); err != nil {
+			writeJSON(writer, http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(writer, http.StatusOK, map[string]bool{"ready": true})
+	})
+}
+
+// Функция dependenciesHandler отдает состояние зависимостей, приостановку обработки и отставание потребителей,
+// 503 - пока обработка приостановлена
+func dependenciesHandler(dependencies *health.Monitor, lagMonitor *consumer.LagMonitor) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		paused := dependencies.Healthy() != nil
+		writeJSON(writer, lo.Ternary(paused, http.StatusServiceUnavailable, http.StatusOK), dependenciesResponse{
+			Paused:       paused,
+			Dependencies: dependencies.States(),
+			ConsumerLag:  lagMonitor.State(),
+		})
+	})
+}
+
+// Структура dependenciesResponse описывает ответ эндпоинта состояния зависимостей
+type dependenciesResponse struct {
+	Paused       bool                     `json:"paused"`
+	Dependencies []health.DependencyState `json:"dependencies"`
+	ConsumerLag  consumer.LagState        `json:"consumer_lag"`
+}
+
+// Функция statusOverridesHandler отдает настроенные замены статуса по значениям атрибутов товара
+func statusOverridesHandler(overrides StatusOverrides) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodGet {
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(writer, http.StatusOK, lo.Ternary(overrides == nil, StatusOverrides{}, overrides))
+	})
+}
+
+// Функция writeJSON отправляет ответ в формате JSON
+func writeJSON(writer http.ResponseWriter, status int, body any) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	_ = json.NewEncoder(writer).Encode(body)
+}
+
+// Функция writeError отправляет ошибку, ошибки валидации отдаются с кодом 400, занятая блокировка индексации и идущее повторное чтение топика - 409
+func writeError(writer http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var invalidArgument *custom_error.InvalidArgument
+	switch {
+	case errors.As(err, &invalidArgument):
+		status = http.StatusBadRequest
+	case errors.Is(err, service.ErrIndexingInProgress), errors.Is(err, consumer.ErrTopicReplayInProgress):
+		status = http.StatusConflict
+	}
+	writeJSON(writer, status, map[string]string{"error": err.Error()})
+}
+
 // Функция loggingHandler добавляет логирование к HTTP запросам
 func loggingHandler(h http.Handler, logger *zap.Logger) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
@@ -0,0 +1,60 @@
+package bootstrap
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/samber/lo"
+	"gitlab.int.tsum.com/preowned/simona/delta/core.git/custom_error"
+
+	"offer-read-service/internal/ratelimit"
+)
+
+// Запрос на изменение ограничений для внешнего сервиса, незаданные поля остаются без изменений
+type rateLimitUpdate struct {
+	Upstream    string   `json:"upstream"`
+	Rate        *float64 `json:"rate"`
+	Concurrency *int     `json:"concurrency"`
+}
+
+// Функция rateLimitHandler отдает текущее состояние ограничителей (GET) и меняет ограничения без перезапуска (POST)
+func rateLimitHandler(limiters []*ratelimit.Limiter) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch request.Method {
+		case http.MethodGet:
+			writeJSON(writer, http.StatusOK, lo.Map(limiters, func(item *ratelimit.Limiter, _ int) ratelimit.State {
+				return item.State()
+			}))
+		case http.MethodPost:
+			var update rateLimitUpdate
+			if err := json.NewDecoder(request.Body).Decode(&update); err != nil {
+				writeError(writer, &custom_error.InvalidArgument{Message: fmt.Sprintf("can't decode rate limit: %s", err)})
+				return
+			}
+			if (update.Rate != nil && *update.Rate < 0) || (update.Concurrency != nil && *update.Concurrency < 0) {
+				writeError(writer, &custom_error.InvalidArgument{Message: "rate and concurrency must not be negative"})
+				return
+			}
+			limiter, ok := lo.Find(limiters, func(item *ratelimit.Limiter) bool {
+				return item.State().Upstream == update.Upstream
+			})
+			if !ok {
+				writeError(writer, &custom_error.InvalidArgument{Message: fmt.Sprintf("unknown upstream %q", update.Upstream)})
+				return
+			}
+
+			config := limiter.State().Config
+			if update.Rate != nil {
+				config.Rate = *update.Rate
+			}
+			if update.Concurrency != nil {
+				config.Concurrency = *update.Concurrency
+			}
+			limiter.SetConfig(config)
+			writeJSON(writer, http.StatusOK, limiter.State())
+		default:
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+}
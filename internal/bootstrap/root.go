@@ -3,6 +3,8 @@ package bootstrap
 import (
 	"context"
 	"fmt"
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/getsentry/sentry-go"
 	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
 	"github.com/samber/lo"
 	"gitlab.int.tsum.com/core/libraries/corekit.git/kafka/broker"
@@ -11,27 +13,35 @@ import (
 	"gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/catalog_read_service"
 	"gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/catalog_write"
 	"gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/offer_service"
-	"gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/stock"
 	"gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/stock_service"
 	grpc_helper "gitlab.int.tsum.com/preowned/simona/delta/core.git/grpc"
-	"gitlab.int.tsum.com/preowned/simona/delta/core.git/retrying_consumer"
 	"go.elastic.co/apm/module/apmgrpc"
+	"go.elastic.co/apm/v2"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
 	"net"
 	"net/http"
+	"offer-read-service/internal/cache"
 	"offer-read-service/internal/consumer"
+	"offer-read-service/internal/health"
+	"offer-read-service/internal/ratelimit"
 	"offer-read-service/internal/repository"
 	"offer-read-service/internal/service"
 	"offer-read-service/internal/service/offer_enricher"
+	"offer-read-service/internal/upstream"
+	"os"
 	"sync"
+	"time"
 )
 
 // Объявляем константу для имени сервиса.
 const (
 	serviceName = "offer-read"
+
+	// Имя зависимости, от которой зависит готовность сервиса
+	elasticsearchDependency = "elasticsearch"
 )
 
 // Определение структуры Root, которая будет являться основным компонентом приложения.
@@ -53,6 +63,10 @@ type Root struct {
 	Repositories struct {
 		OfferRepository       repository.OfferRepository
 		OfferStatusRepository repository.OfferStatusRepository
+		DeadLetterRepository  repository.DeadLetterRepository
+		LeaseRepository       repository.LeaseRepository
+		OutboxRepository      repository.OutboxRepository
+		RecheckRepository     repository.RecheckRepository
 	}
 
 	// Клиенты для взаимодействия с внешними сервисами
@@ -67,6 +81,7 @@ type Root struct {
 	Services struct {
 		Indexator     service.Indexator
 		OfferEnricher service.OfferEnricher
+		Auditor       service.Auditor
 	}
 
 	// Логгер для записи логов
@@ -80,6 +95,28 @@ type Root struct {
 
 	// Трассировщик для мониторинга
 	Tracer *apm.Tracer
+
+	// Планировщик периодической индексации
+	scheduler *scheduler
+
+	// Ограничители запросов индексации к внешним сервисам
+	rateLimiters []*ratelimit.Limiter
+
+	// Повторная обработка событий из топика недоставленных событий
+	deadLetterReplay func(ctx context.Context) (consumer.ReplayResult, error)
+
+	// Мониторинг отставания потребителей Kafka
+	lagMonitor *consumer.LagMonitor
+
+	// Повторное чтение топиков событий с заданного смещения или времени
+	topicReplay *consumer.TopicReplay
+
+	// Мониторинг зависимостей, при недоступности которых потребление событий и индексация приостанавливаются
+	dependencies *health.Monitor
+
+	// Кеши ответов внешних сервисов, nil при выключенном кешировании
+	catalogItemsCache *cache.Cache[*catalog_write.ItemComposite]
+	offersCache       *cache.OfferClient
 }
 
 // Регистрация фоновой задачи
@@ -114,11 +151,14 @@ func NewRoot(ctx context.Context, config *Config, options ...Option) (*Root, err
 
 	// Инициализация компонентов приложения
 	root.initGRPCServer()
-	root.initInfrastructure()
+	root.initInfrastructure(ctx)
 	root.initClients()
 	root.initRepositories()
 	root.initServices()
 	root.initConsumers(ctx)
+	root.initOutboxRelay(ctx)
+	root.initScheduler(ctx)
+	root.initAuditor(ctx)
 	root.initHTTPServer()
 	lo.Must0(root.initSentry())
 
@@ -226,37 +266,63 @@ func (r *Root) initGRPCServer() {
 }
 
 func (r *Root) initClients() {
-	conn, err := dial(r.Config.GrpcClientConfig.OfferEndpoint)
+	conn, err := dial(r.Config.GrpcClientConfig.OfferEndpoint, "offer", r.Config.Upstream.Offer, r.rateLimiter("offer", r.Config.RateLimit.OfferRate, r.Config.RateLimit.OfferConcurrency))
 	if err != nil {
 		panic(err)
 	}
 	r.Clients.OfferClient = offer_service.NewOfferServiceClient(conn)
+	if r.Config.Cache.Enabled {
+		r.offersCache = cache.NewOfferClient(r.Clients.OfferClient, r.Config.Cache.Size, r.Config.Cache.TTL)
+	}
+	r.dependencies.Register("offer_service", health.GRPCConnection(conn))
 
-	conn, err = dial(r.Config.GrpcClientConfig.CatalogReadEndpoint)
+	conn, err = dial(r.Config.GrpcClientConfig.CatalogReadEndpoint, "catalog_read", r.Config.Upstream.CatalogRead, r.rateLimiter("catalog_read", r.Config.RateLimit.CatalogReadRate, r.Config.RateLimit.CatalogReadConcurrency))
 	if err != nil {
 		panic(err)
 	}
 	r.Clients.CatalogReadClient = catalog_read_service.NewCatalogReadSearchServiceClient(conn)
 
-	conn, err = dial(r.Config.GrpcClientConfig.CatalogWriteEndpoint)
+	conn, err = dial(r.Config.GrpcClientConfig.CatalogWriteEndpoint, "catalog_write", r.Config.Upstream.CatalogWrite, r.rateLimiter("catalog_write", r.Config.RateLimit.CatalogWriteRate, r.Config.RateLimit.CatalogWriteConcurrency))
 	if err != nil {
 		panic(err)
 	}
 	r.Clients.CatalogWriteClient = catalog_write.NewCatalogWriteServiceClient(conn)
+	if r.Config.Cache.Enabled {
+		r.catalogItemsCache = cache.New[*catalog_write.ItemComposite]("catalog_items", r.Config.Cache.Size, r.Config.Cache.TTL)
+		r.Clients.CatalogWriteClient = cache.CatalogWriteClient(r.Clients.CatalogWriteClient, r.catalogItemsCache)
+	}
 
-	conn, err = dial(r.Config.GrpcClientConfig.StockEndpoint)
+	conn, err = dial(r.Config.GrpcClientConfig.StockEndpoint, "stock", r.Config.Upstream.Stock, r.rateLimiter("stock", r.Config.RateLimit.StockRate, r.Config.RateLimit.StockConcurrency))
 	if err != nil {
 		panic(err)
 	}
 	r.Clients.StockClient = stock_service.NewStockServiceClient(conn)
+	r.dependencies.Register("stock_service", health.GRPCConnection(conn))
 
 }
 
-func dial(target string) (*grpc.ClientConn, error) {
+func (r *Root) rateLimiter(upstream string, rate float64, concurrency int) *ratelimit.Limiter {
+	limiter := ratelimit.NewLimiter(upstream, ratelimit.Config{
+		Rate:               rate,
+		Concurrency:        concurrency,
+		LatencyThreshold:   r.Config.RateLimit.LatencyThreshold,
+		ErrorRateThreshold: r.Config.RateLimit.ErrorRateThreshold,
+	})
+	r.rateLimiters = append(r.rateLimiters, limiter)
+	return limiter
+}
+
+func dial(target string, name string, config UpstreamConfig, limiter *ratelimit.Limiter) (*grpc.ClientConn, error) {
+	// Ограничитель ждет снаружи размыкателя и таймаутов запроса
+	var outer []grpc.UnaryClientInterceptor
+	if limiter != nil {
+		outer = append(outer, limiter.UnaryClientInterceptor())
+	}
+	interceptors := append([]grpc.UnaryClientInterceptor{apmgrpc.NewUnaryClientInterceptor()}, upstream.Interceptors(name, config.upstream(), outer...)...)
 	conn, err := grpc.Dial(
 		target,
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
-		grpc.WithUnaryInterceptor(apmgrpc.NewUnaryClientInterceptor()),
+		grpc.WithChainUnaryInterceptor(interceptors...),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("grpc.Dial to '%s' service: '%w'", target, err)
@@ -264,7 +330,19 @@ func dial(target string) (*grpc.ClientConn, error) {
 	return conn, nil
 }
 
-func (r *Root) initInfrastructure() {
+// Функция upstream собирает настройки запросов к внешнему сервису
+func (c UpstreamConfig) upstream() upstream.Config {
+	return upstream.Config{
+		Timeout:            c.Timeout,
+		AttemptTimeout:     c.AttemptTimeout,
+		Retries:            c.Retries,
+		RetryBackoff:       c.RetryBackoff,
+		BreakerFailures:    c.BreakerFailures,
+		BreakerOpenTimeout: c.BreakerOpenTimeout,
+	}
+}
+
+func (r *Root) initInfrastructure(ctx context.Context) {
 	r.Infrastructure.KafkaConsumer = broker.NewConsumer(r.Config.Kafka.Config, r.Logger)
 	client, err := elasticsearch.NewClient(elasticsearch.Config{
 		Addresses: r.Config.Elastic.Addresses,
@@ -273,6 +351,16 @@ func (r *Root) initInfrastructure() {
 		panic(err)
 	}
 	r.Infrastructure.Elasticsearch = client
+
+	// Без Elasticsearch и сервисов предложений и запасов индексация невозможна, они проверяются постоянно
+	r.dependencies = health.NewMonitor(r.Config.Health.FailureThreshold)
+	r.dependencies.Register(elasticsearchDependency, func(ctx context.Context) error {
+		return repository.CheckElasticHealth(ctx, client, r.Config.Elastic.OfferIndexName)
+	})
+	r.RegisterBackgroundJob(func() error {
+		r.dependencies.Run(ctxzap.ToContext(ctx, r.Logger.Named("health")), r.Config.Health.CheckInterval)
+		return nil
+	})
 }
 
 func (r *Root) initRepositories() {
@@ -282,6 +370,34 @@ func (r *Root) initRepositories() {
 	}
 	r.Repositories.OfferRepository = repo
 
+	deadLetterRepo, err := repository.NewElasticDeadLetterRepo(r.Infrastructure.Elasticsearch, r.Config.Elastic.DeadLetterIndexName)
+	if err != nil {
+		panic(err)
+	}
+	r.Repositories.DeadLetterRepository = deadLetterRepo
+
+	leaseRepo, err := repository.NewElasticLeaseRepo(r.Infrastructure.Elasticsearch, r.Config.Elastic.LeaseIndexName)
+	if err != nil {
+		panic(err)
+	}
+	r.Repositories.LeaseRepository = leaseRepo
+
+	recheckRepo, err := repository.NewElasticRecheckRepo(r.Infrastructure.Elasticsearch, r.Config.Elastic.RecheckIndexName)
+	if err != nil {
+		panic(err)
+	}
+	r.Repositories.RecheckRepository = recheckRepo
+
+	// Изменения статуса записываются в outbox на пути записи (индексатор и потребители) и публикуются в Kafka отдельно
+	if r.Config.Kafka.OfferStatusChangedTopic != "" {
+		outboxRepo, err := repository.NewElasticOutboxRepo(r.Infrastructure.Elasticsearch, r.Config.Elastic.OutboxIndexName)
+		if err != nil {
+			panic(err)
+		}
+		r.Repositories.OutboxRepository = outboxRepo
+		r.Repositories.OfferRepository = service.NewStatusChangeRecorder(r.Repositories.OfferRepository, outboxRepo)
+	}
+
 	offerStatusRepository, _ := repository.NewOfferStatusRepository()
 	r.Repositories.OfferStatusRepository = offerStatusRepository
 }
@@ -292,15 +408,58 @@ func (r *Root) initServices() {
 		r.Clients.CatalogWriteClient,
 		r.Clients.StockClient,
 		r.Repositories.OfferRepository,
+		r.Config.IndexatorConfig.EnrichTimeout,
+		r.Config.IndexatorConfig.EnrichChunkSize,
+		r.Config.IndexatorConfig.PriceRules,
+		r.Config.IndexatorConfig.StatusOverrides,
 	)
 	r.Services.Indexator = service.NewIndexator(
-		r.Clients.OfferClient,
+		r.indexingOfferClient(),
 		r.Repositories.OfferRepository,
+		r.Repositories.DeadLetterRepository,
 		r.Config.IndexatorConfig.IndexPerPage,
 		r.Services.OfferEnricher,
+		service.RetryPolicy{
+			Attempts:       r.Config.IndexatorConfig.RetryAttempts,
+			Delay:          r.Config.IndexatorConfig.RetryDelay,
+			MaxFailedPages: r.Config.IndexatorConfig.MaxFailedPages,
+		},
+		service.NewLeaseLocker(
+			r.Repositories.LeaseRepository,
+			service.IndexingLeaseName,
+			leaseHolder(),
+			r.Config.IndexatorConfig.LeaseTTL,
+			r.Config.IndexatorConfig.LeaseHeartbeat,
+		),
+		r.dependencies,
+	)
+	r.Services.Auditor = service.NewAuditor(
+		r.Clients.OfferClient,
+		r.Repositories.OfferRepository,
+		r.Services.OfferEnricher,
+		r.Config.Auditor.SampleSize,
+		r.Config.Auditor.Fix,
 	)
 }
 
+// Клиент сервиса предложений для индексации и обновления предложений по событиям, с кешем, если он включен.
+// Чтение предложений через gRPC сервер и аудит идут в сервис предложений напрямую
+func (r *Root) indexingOfferClient() offer_service.OfferServiceClient {
+	if r.offersCache != nil {
+		return r.offersCache
+	}
+	return r.Clients.OfferClient
+}
+
+// Идентификатор экземпляра сервиса, удерживающего блокировку индексации
+func leaseHolder() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
+
 func (r *Root) initSentry() error {
 	err := sentry.Init(sentry.ClientOptions{
 		Dsn:              r.Config.Sentry.DSN,
@@ -315,16 +474,56 @@ func (r *Root) initSentry() error {
 	return nil
 }
 
-func (r *Root) initConsumers(ctx context.Context) {
+// Публикация изменений статуса из outbox, публикует только владелец блокировки
+func (r *Root) initOutboxRelay(ctx context.Context) {
+	if r.Repositories.OutboxRepository == nil {
+		return
+	}
+	relay := consumer.NewOutboxRelay(
+		r.Config.Kafka.Config,
+		r.Config.Kafka.OfferStatusChangedTopic,
+		r.Repositories.OutboxRepository,
+		r.Repositories.OfferRepository,
+		service.NewLeaseLocker(
+			r.Repositories.LeaseRepository,
+			consumer.OutboxRelayLeaseName,
+			leaseHolder(),
+			r.Config.IndexatorConfig.LeaseTTL,
+			r.Config.IndexatorConfig.LeaseHeartbeat,
+		),
+		r.Config.Kafka.OutboxBatchSize,
+	)
+	r.RegisterStopHandler(func() { _ = relay.Close() })
 	r.RegisterBackgroundJob(func() error {
-		retrying_consumer.NewConsumer[stock.StockUnitReservedEvent](
-			r.Config.Kafka.ConsumerGroupId,
-			r.Config.Kafka.StockUnitReservedTopic,
-			r.Infrastructure.KafkaConsumer,
-			consumer.RetryingMessageHandler(consumer.StockUnitReserved(r.Clients.OfferClient, r.Services.OfferEnricher, r.Repositories.OfferRepository)),
-			r.Tracer,
-			r.Logger,
-		).Run(ctx)
+		relay.Run(ctxzap.ToContext(ctx, r.Logger.Named("outbox_relay")), r.Config.Kafka.OutboxRelayInterval)
 		return nil
 	})
 }
+
+func (r *Root) initAuditor(ctx context.Context) {
+	if !r.Config.Auditor.Enabled {
+		return
+	}
+	r.RegisterBackgroundJob(func() error {
+		ctx := ctxzap.ToContext(ctx, r.Logger.Named("auditor"))
+		ticker := time.NewTicker(r.Config.Auditor.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			}
+			result, err := r.Services.Auditor.Audit(ctx)
+			if err != nil {
+				ctxzap.Error(ctx, "audit failed", zap.Error(err))
+				continue
+			}
+			ctxzap.Debug(ctx, "audit finished",
+				zap.Int("num_audited", result.NumAudited),
+				zap.Int("num_mismatched", result.NumMismatched),
+				zap.Int("num_fixed", result.NumFixed),
+			)
+		}
+	})
+}
@@ -0,0 +1,245 @@
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/robfig/cron/v3"
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+
+	"offer-read-service/internal/service"
+)
+
+// Названия задач планировщика, используются в метриках и статусе
+const (
+	jobFullIndex        = "full_index"
+	jobIncrementalIndex = "incremental_index"
+)
+
+// Результаты запуска задачи для метрик
+const (
+	jobResultSuccess = "success"
+	jobResultError   = "error"
+	jobResultSkipped = "skipped"
+)
+
+// Метрики планировщика индексации
+var (
+	indexingLastSuccess = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "offer_read",
+		Subsystem: "indexing",
+		Name:      "last_success_timestamp_seconds",
+		Help:      "Unix time of the last successful indexing run.",
+	}, []string{"job"})
+	indexingRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "offer_read",
+		Subsystem: "indexing",
+		Name:      "runs_total",
+		Help:      "Number of scheduled indexing runs by result.",
+	}, []string{"job", "result"})
+	indexingDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "offer_read",
+		Subsystem: "indexing",
+		Name:      "duration_seconds",
+		Help:      "Duration of scheduled indexing runs.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 14),
+	}, []string{"job"})
+)
+
+// Определение структуры jobStatus, описывающей состояние задачи планировщика
+type jobStatus struct {
+	Name         string    `json:"name"`
+	Schedule     string    `json:"schedule"`
+	Running      bool      `json:"running"`
+	NextRun      time.Time `json:"next_run"`
+	LastStarted  time.Time `json:"last_started"`
+	LastFinished time.Time `json:"last_finished"`
+	LastSuccess  time.Time `json:"last_success"`
+	LastError    string    `json:"last_error,omitempty"`
+	NumIndexed   int       `json:"num_indexed"`
+	LockHolder   string    `json:"lock_holder,omitempty"`
+}
+
+// Определение структуры scheduledJob для задачи, запускаемой по cron-выражению
+type scheduledJob struct {
+	name     string
+	schedule string
+	entryID  cron.EntryID
+	run      func(ctx context.Context) (int, error)
+	running  atomic.Bool
+	mu       sync.Mutex
+	status   jobStatus
+}
+
+// Определение структуры scheduler - планировщика периодической индексации
+type scheduler struct {
+	cron      *cron.Cron
+	jitter    time.Duration
+	jobs      []*scheduledJob
+	indexator service.Indexator
+	// Любая задача пропускается, пока выполняется другая, чтобы полная и инкрементальная индексации не пересекались
+	running atomic.Bool
+}
+
+// Функция newScheduler создает планировщик и регистрирует задачи с непустым расписанием
+func newScheduler(ctx context.Context, config SchedulerConfig, indexator service.Indexator) (*scheduler, error) {
+	s := &scheduler{
+		cron:      cron.New(),
+		jitter:    config.Jitter,
+		indexator: indexator,
+	}
+
+	if err := s.addJob(ctx, jobFullIndex, config.FullIndexCron, s.fullIndex); err != nil {
+		return nil, err
+	}
+	if err := s.addJob(ctx, jobIncrementalIndex, config.IncrementalIndexCron, s.incrementalIndex); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Метод fullIndex индексирует все предложения
+func (s *scheduler) fullIndex(ctx context.Context) (int, error) {
+	result, err := s.indexator.Index(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return result.NumIndexed, nil
+}
+
+// Метод incrementalIndex индексирует предложения, созданные после самого нового предложения в индексе.
+// Изменения уже проиндексированных предложений приходят событиями Kafka и полной индексацией
+func (s *scheduler) incrementalIndex(ctx context.Context) (int, error) {
+	result, err := s.indexator.IndexNew(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return result.NumIndexed, nil
+}
+
+// Метод addJob добавляет задачу по cron-выражению; пустое выражение отключает задачу
+func (s *scheduler) addJob(ctx context.Context, name, schedule string, run func(ctx context.Context) (int, error)) error {
+	if schedule == "" {
+		return nil
+	}
+	job := &scheduledJob{
+		name:     name,
+		schedule: schedule,
+		run:      run,
+		status:   jobStatus{Name: name, Schedule: schedule},
+	}
+	entryID, err := s.cron.AddFunc(schedule, func() { s.runJob(ctx, job) })
+	if err != nil {
+		return fmt.Errorf("invalid cron expression for %s '%s': %w", name, schedule, err)
+	}
+	job.entryID = entryID
+	s.jobs = append(s.jobs, job)
+	return nil
+}
+
+// Метод Run запускает планировщик и блокируется до отмены контекста
+func (s *scheduler) Run(ctx context.Context) error {
+	s.cron.Start()
+	<-ctx.Done()
+	<-s.cron.Stop().Done()
+	return nil
+}
+
+// Метод runJob выполняет задачу, пропуская запуск, если еще выполняется любая задача планировщика
+func (s *scheduler) runJob(ctx context.Context, job *scheduledJob) {
+	if !s.running.CompareAndSwap(false, true) {
+		indexingRuns.WithLabelValues(job.name, jobResultSkipped).Inc()
+		return
+	}
+	defer s.running.Store(false)
+	job.running.Store(true)
+	defer job.running.Store(false)
+
+	ctx = ctxzap.ToContext(ctx, ctxzap.Extract(ctx).Named(job.name))
+
+	// Случайная задержка, чтобы реплики и задачи не стартовали одновременно
+	if s.jitter > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(rand.Int63n(int64(s.jitter)))):
+		}
+	}
+
+	started := time.Now()
+	job.mu.Lock()
+	job.status.LastStarted = started
+	job.mu.Unlock()
+
+	ctxzap.Info(ctx, "scheduled indexing is starting")
+	numIndexed, err := job.run(ctx)
+	indexingDuration.WithLabelValues(job.name).Observe(time.Since(started).Seconds())
+
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.status.LastFinished = time.Now()
+	switch {
+	case errors.Is(err, service.ErrIndexingInProgress):
+		ctxzap.Info(ctx, "scheduled indexing skipped, indexing is already started")
+		indexingRuns.WithLabelValues(job.name, jobResultSkipped).Inc()
+	case err != nil:
+		ctxzap.Error(ctx, "scheduled indexing failed", zap.Error(err))
+		indexingRuns.WithLabelValues(job.name, jobResultError).Inc()
+		job.status.LastError = err.Error()
+	default:
+		ctxzap.Info(ctx, "scheduled indexing finished", zap.Int("num_indexed", numIndexed), zap.Duration("elapsed", time.Since(started)))
+		indexingRuns.WithLabelValues(job.name, jobResultSuccess).Inc()
+		indexingLastSuccess.WithLabelValues(job.name).SetToCurrentTime()
+		job.status.LastSuccess = job.status.LastFinished
+		job.status.LastError = ""
+		job.status.NumIndexed = numIndexed
+	}
+}
+
+// Метод Status возвращает текущее состояние всех задач планировщика и владельца блокировки индексации
+func (s *scheduler) Status(ctx context.Context) []jobStatus {
+	var lockHolder string
+	if lease, err := s.indexator.LockHolder(ctx); err == nil && lease != nil {
+		lockHolder = lease.Holder
+	}
+
+	statuses := make([]jobStatus, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		job.mu.Lock()
+		status := job.status
+		job.mu.Unlock()
+		status.Running = job.running.Load()
+		status.NextRun = s.cron.Entry(job.entryID).Next
+		status.LockHolder = lockHolder
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// Функция schedulerStatusHandler отдает состояние задач планировщика
+func schedulerStatusHandler(s *scheduler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writeJSON(writer, http.StatusOK, s.Status(request.Context()))
+	})
+}
+
+// Инициализация планировщика периодической индексации
+func (r *Root) initScheduler(ctx context.Context) {
+	if !r.Config.Scheduler.Enabled {
+		return
+	}
+	r.scheduler = lo.Must(newScheduler(ctx, r.Config.Scheduler, r.Services.Indexator))
+	r.RegisterBackgroundJob(func() error {
+		return r.scheduler.Run(ctx)
+	})
+}
@@ -0,0 +1,63 @@
+package bootstrap
+
+import (
+	"context"
+	"testing"
+
+	"offer-read-service/internal/service"
+)
+
+type schedulerIndexator struct {
+	service.Indexator
+	indexErr error
+	indexing chan struct{}
+	release  chan struct{}
+	newCalls int
+}
+
+func (i *schedulerIndexator) Index(context.Context) (service.IndexingResult, error) {
+	if i.indexing != nil {
+		close(i.indexing)
+		<-i.release
+	}
+	return service.IndexingResult{NumIndexed: 1}, i.indexErr
+}
+
+func (i *schedulerIndexator) IndexNew(context.Context) (service.IndexingResult, error) {
+	i.newCalls++
+	return service.IndexingResult{NumIndexed: 1}, nil
+}
+
+func newTestScheduler(indexator service.Indexator) (*scheduler, *scheduledJob, *scheduledJob) {
+	s := &scheduler{indexator: indexator}
+	full := &scheduledJob{name: jobFullIndex, run: s.fullIndex}
+	incremental := &scheduledJob{name: jobIncrementalIndex, run: s.incrementalIndex}
+	return s, full, incremental
+}
+
+func Test_scheduler_skipsWhileAnotherJobRuns(t *testing.T) {
+	indexator := &schedulerIndexator{indexing: make(chan struct{}), release: make(chan struct{})}
+	s, full, incremental := newTestScheduler(indexator)
+
+	done := make(chan struct{})
+	go func() {
+		s.runJob(context.Background(), full)
+		close(done)
+	}()
+	<-indexator.indexing
+
+	s.runJob(context.Background(), incremental)
+	if indexator.newCalls != 0 {
+		t.Errorf("incremental index ran while the full index was running")
+	}
+	if !full.running.Load() || incremental.running.Load() {
+		t.Errorf("running = full %v, incremental %v, want true, false", full.running.Load(), incremental.running.Load())
+	}
+
+	close(indexator.release)
+	<-done
+	s.runJob(context.Background(), incremental)
+	if indexator.newCalls != 1 {
+		t.Errorf("incremental index didn't run after the full index finished")
+	}
+}
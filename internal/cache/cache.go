@@ -0,0 +1,162 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	hits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "offer_read",
+		Subsystem: "cache",
+		Name:      "hits_total",
+		Help:      "Number of keys found in the cache.",
+	}, []string{"cache"})
+	misses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "offer_read",
+		Subsystem: "cache",
+		Name:      "misses_total",
+		Help:      "Number of keys missing or expired in the cache.",
+	}, []string{"cache"})
+	entries = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "offer_read",
+		Subsystem: "cache",
+		Name:      "entries",
+		Help:      "Number of keys stored in the cache.",
+	}, []string{"cache"})
+)
+
+type entry[V any] struct {
+	key       string
+	value     V
+	expiresAt time.Time
+}
+
+// Cache keeps at most size values for ttl, the least recently used values are evicted first.
+// A nil Cache stores nothing.
+type Cache[V any] struct {
+	name string
+	size int
+	ttl  time.Duration
+	now  func() time.Time
+
+	mu    sync.Mutex
+	items map[string]*list.Element
+	order *list.List
+	// generation changes on every invalidation, invalidated keeps the generation of the last invalidation
+	// of each key, the values of a key fetched before its invalidation are not stored
+	generation  uint64
+	invalidated map[string]uint64
+	// the values fetched before floor are not stored, it moves once invalidated outgrows the cache size
+	floor uint64
+}
+
+func New[V any](name string, size int, ttl time.Duration) *Cache[V] {
+	return &Cache[V]{
+		name:        name,
+		size:        size,
+		ttl:         ttl,
+		now:         time.Now,
+		items:       map[string]*list.Element{},
+		order:       list.New(),
+		invalidated: map[string]uint64{},
+	}
+}
+
+// Get returns the cached values and the keys which have to be fetched.
+func (c *Cache[V]) Get(keys []string) (map[string]V, []string) {
+	if c == nil {
+		return nil, keys
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	found := make(map[string]V, len(keys))
+	var missing []string
+	now := c.now()
+	for _, key := range keys {
+		element, ok := c.items[key]
+		if ok && now.Before(element.Value.(*entry[V]).expiresAt) {
+			c.order.MoveToFront(element)
+			found[key] = element.Value.(*entry[V]).value
+			continue
+		}
+		if ok {
+			c.remove(element)
+		}
+		missing = append(missing, key)
+	}
+	hits.WithLabelValues(c.name).Add(float64(len(found)))
+	misses.WithLabelValues(c.name).Add(float64(len(missing)))
+	return found, missing
+}
+
+// Generation has to be taken before the values are fetched and passed to Set.
+func (c *Cache[V]) Generation() uint64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// Set skips the keys invalidated after the generation was taken,
+// their values might be fetched before the invalidation and be stale already.
+func (c *Cache[V]) Set(generation uint64, values map[string]V) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation < c.floor {
+		return
+	}
+
+	expiresAt := c.now().Add(c.ttl)
+	for key, value := range values {
+		if c.invalidated[key] > generation {
+			continue
+		}
+		if element, ok := c.items[key]; ok {
+			element.Value = &entry[V]{key: key, value: value, expiresAt: expiresAt}
+			c.order.MoveToFront(element)
+			continue
+		}
+		c.items[key] = c.order.PushFront(&entry[V]{key: key, value: value, expiresAt: expiresAt})
+		for c.order.Len() > c.size {
+			c.remove(c.order.Back())
+		}
+	}
+	entries.WithLabelValues(c.name).Set(float64(c.order.Len()))
+}
+
+// Invalidate drops the values of the keys.
+func (c *Cache[V]) Invalidate(keys ...string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	for _, key := range keys {
+		c.invalidated[key] = c.generation
+		if element, ok := c.items[key]; ok {
+			c.remove(element)
+		}
+	}
+	if len(c.invalidated) > c.size {
+		c.invalidated = map[string]uint64{}
+		c.floor = c.generation
+	}
+	entries.WithLabelValues(c.name).Set(float64(c.order.Len()))
+}
+
+func (c *Cache[V]) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.items, element.Value.(*entry[V]).key)
+}
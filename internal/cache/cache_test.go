@@ -0,0 +1,150 @@
+package cache
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/offer_service"
+	"google.golang.org/grpc"
+)
+
+func TestCache(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name        string
+		act         func(c *Cache[int])
+		wantFound   map[string]int
+		wantMissing []string
+	}{
+		{
+			name:        "hit",
+			act:         func(c *Cache[int]) { c.Set(c.Generation(), map[string]int{"A": 1, "B": 2}) },
+			wantFound:   map[string]int{"A": 1, "B": 2},
+			wantMissing: []string{"C"},
+		},
+		{
+			name: "expired",
+			act: func(c *Cache[int]) {
+				c.Set(c.Generation(), map[string]int{"A": 1, "B": 2})
+				c.now = func() time.Time { return now.Add(time.Minute) }
+			},
+			wantFound:   map[string]int{},
+			wantMissing: []string{"A", "B", "C"},
+		},
+		{
+			name: "least_recently_used_evicted",
+			act: func(c *Cache[int]) {
+				c.Set(c.Generation(), map[string]int{"A": 1})
+				c.Set(c.Generation(), map[string]int{"B": 2})
+				c.Get([]string{"A"})
+				c.Set(c.Generation(), map[string]int{"C": 3})
+			},
+			wantFound:   map[string]int{"A": 1, "C": 3},
+			wantMissing: []string{"B"},
+		},
+		{
+			name: "invalidated",
+			act: func(c *Cache[int]) {
+				c.Set(c.Generation(), map[string]int{"A": 1, "B": 2})
+				c.Invalidate("A")
+			},
+			wantFound:   map[string]int{"B": 2},
+			wantMissing: []string{"A", "C"},
+		},
+		{
+			name: "fetched_before_invalidation",
+			act: func(c *Cache[int]) {
+				generation := c.Generation()
+				c.Invalidate("A")
+				c.Set(generation, map[string]int{"A": 1})
+			},
+			wantFound:   map[string]int{},
+			wantMissing: []string{"A", "B", "C"},
+		},
+		{
+			name: "other_key_invalidated",
+			act: func(c *Cache[int]) {
+				generation := c.Generation()
+				c.Invalidate("C")
+				c.Set(generation, map[string]int{"A": 1, "C": 3})
+			},
+			wantFound:   map[string]int{"A": 1},
+			wantMissing: []string{"B", "C"},
+		},
+		{
+			name: "invalidations_outgrow_cache",
+			act: func(c *Cache[int]) {
+				generation := c.Generation()
+				c.Invalidate("X", "Y", "Z")
+				c.Set(generation, map[string]int{"A": 1})
+				c.Set(c.Generation(), map[string]int{"B": 2})
+			},
+			wantFound:   map[string]int{"B": 2},
+			wantMissing: []string{"A", "C"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New[int]("test", 2, time.Second)
+			c.now = func() time.Time { return now }
+			tt.act(c)
+
+			found, missing := c.Get([]string{"A", "B", "C"})
+			if !reflect.DeepEqual(found, tt.wantFound) {
+				t.Errorf("Get() found = %v, want %v", found, tt.wantFound)
+			}
+			if !reflect.DeepEqual(missing, tt.wantMissing) {
+				t.Errorf("Get() missing = %v, want %v", missing, tt.wantMissing)
+			}
+		})
+	}
+}
+
+type offerServiceClient struct {
+	offer_service.OfferServiceClient
+	requested [][]string
+}
+
+func (c *offerServiceClient) SearchOffers(_ context.Context, in *offer_service.SearchOffersRequest, _ ...grpc.CallOption) (*offer_service.SearchOffersResponse, error) {
+	codes := append([]string(nil), in.OfferCodes...)
+	sort.Strings(codes)
+	c.requested = append(c.requested, codes)
+	response := &offer_service.SearchOffersResponse{}
+	for _, code := range in.OfferCodes {
+		response.Offer = append(response.Offer, &offer_service.Offer{OfferCode: code})
+	}
+	return response, nil
+}
+
+func TestOfferClient_SearchOffers(t *testing.T) {
+	upstream := &offerServiceClient{}
+	client := NewOfferClient(upstream, 10, time.Minute)
+	search := func(request *offer_service.SearchOffersRequest) []string {
+		response, err := client.SearchOffers(context.Background(), request)
+		if err != nil {
+			t.Fatalf("SearchOffers() error = %v", err)
+		}
+		var codes []string
+		for _, offer := range response.Offer {
+			codes = append(codes, offer.OfferCode)
+		}
+		return codes
+	}
+
+	search(&offer_service.SearchOffersRequest{OfferCodes: []string{"A", "B"}})
+	if got := search(&offer_service.SearchOffersRequest{OfferCodes: []string{"B", "C"}}); !reflect.DeepEqual(got, []string{"B", "C"}) {
+		t.Errorf("SearchOffers() = %v, want [B C]", got)
+	}
+	client.Invalidate("A")
+	search(&offer_service.SearchOffersRequest{OfferCodes: []string{"A", "B"}})
+	search(&offer_service.SearchOffersRequest{OfferCodes: []string{"A"}, PriceFilter: offer_service.OfferPriceFilter_OFFER_PRICE_FILTER_WITH_EMPTY_PRICE})
+	search(&offer_service.SearchOffersRequest{OfferCodes: []string{"A"}, Sort: &offer_service.Sort{Field: offer_service.SortField_ID}})
+
+	want := [][]string{{"A", "B"}, {"C"}, {"A"}, {"A"}, {"A"}}
+	if !reflect.DeepEqual(upstream.requested, want) {
+		t.Errorf("upstream requests = %v, want %v", upstream.requested, want)
+	}
+}
@@ -0,0 +1,126 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/samber/lo"
+	"gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/catalog_write"
+	"gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/offer_service"
+	"google.golang.org/grpc"
+)
+
+type catalogWriteClient struct {
+	catalog_write.CatalogWriteServiceClient
+	items *Cache[*catalog_write.ItemComposite]
+}
+
+// CatalogWriteClient serves GetItemListByCodes from the items cache and fetches only the missing items.
+func CatalogWriteClient(client catalog_write.CatalogWriteServiceClient, items *Cache[*catalog_write.ItemComposite]) catalog_write.CatalogWriteServiceClient {
+	return &catalogWriteClient{CatalogWriteServiceClient: client, items: items}
+}
+
+func (c *catalogWriteClient) GetItemListByCodes(ctx context.Context, in *catalog_write.GetItemListByCodesRequest, opts ...grpc.CallOption) (*catalog_write.GetItemListByCodesResponse, error) {
+	codes := lo.Uniq(in.Codes)
+	found, missing := c.items.Get(codes)
+	if len(missing) > 0 {
+		generation := c.items.Generation()
+		response, err := c.CatalogWriteServiceClient.GetItemListByCodes(ctx, &catalog_write.GetItemListByCodesRequest{Codes: missing}, opts...)
+		if err != nil {
+			return nil, err
+		}
+		fetched := lo.SliceToMap(response.Data, func(item *catalog_write.ItemComposite) (string, *catalog_write.ItemComposite) {
+			return item.Item.Code, item
+		})
+		c.items.Set(generation, fetched)
+		found = lo.Assign(found, fetched)
+	}
+	return &catalog_write.GetItemListByCodesResponse{Data: lo.FilterMap(codes, func(code string, _ int) (*catalog_write.ItemComposite, bool) {
+		item, ok := found[code]
+		return item, ok
+	})}, nil
+}
+
+// OfferClient serves SearchOffers by offer codes from the cache, the offers are cached per price filter.
+// Requests with sorting or paging over the codes go to the offer service as they are.
+type OfferClient struct {
+	offer_service.OfferServiceClient
+	size int
+	ttl  time.Duration
+
+	mu     sync.Mutex
+	caches map[offer_service.OfferPriceFilter]*Cache[*offer_service.Offer]
+}
+
+func NewOfferClient(client offer_service.OfferServiceClient, size int, ttl time.Duration) *OfferClient {
+	return &OfferClient{
+		OfferServiceClient: client,
+		size:               size,
+		ttl:                ttl,
+		caches:             map[offer_service.OfferPriceFilter]*Cache[*offer_service.Offer]{},
+	}
+}
+
+func (c *OfferClient) offers(filter offer_service.OfferPriceFilter) *Cache[*offer_service.Offer] {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.caches[filter] == nil {
+		c.caches[filter] = New[*offer_service.Offer](fmt.Sprintf("offers_%d", filter), c.size, c.ttl)
+	}
+	return c.caches[filter]
+}
+
+// Invalidate drops the offers from the caches of all price filters, a nil client has nothing to drop.
+func (c *OfferClient) Invalidate(offerCodes ...string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, offers := range c.caches {
+		offers.Invalidate(offerCodes...)
+	}
+}
+
+func (c *OfferClient) SearchOffers(ctx context.Context, in *offer_service.SearchOffersRequest, opts ...grpc.CallOption) (*offer_service.SearchOffersResponse, error) {
+	if !byCodes(in) {
+		return c.OfferServiceClient.SearchOffers(ctx, in, opts...)
+	}
+	offers := c.offers(in.PriceFilter)
+	codes := lo.Uniq(in.OfferCodes)
+	found, missing := offers.Get(codes)
+	if len(missing) > 0 {
+		generation := offers.Generation()
+		request := &offer_service.SearchOffersRequest{OfferCodes: missing, PriceFilter: in.PriceFilter}
+		if in.Pagination != nil {
+			request.Pagination = &offer_service.Pagination{Limit: lo.ToPtr(int32(len(missing)))}
+		}
+		response, err := c.OfferServiceClient.SearchOffers(ctx, request, opts...)
+		if err != nil {
+			return nil, err
+		}
+		fetched := lo.SliceToMap(response.Offer, func(offer *offer_service.Offer) (string, *offer_service.Offer) {
+			return offer.OfferCode, offer
+		})
+		offers.Set(generation, fetched)
+		found = lo.Assign(found, fetched)
+	}
+	return &offer_service.SearchOffersResponse{Offer: lo.FilterMap(codes, func(code string, _ int) (*offer_service.Offer, bool) {
+		offer, ok := found[code]
+		return offer, ok
+	})}, nil
+}
+
+// byCodes reports whether the request looks the offers up by codes and all of them fit into the response.
+func byCodes(in *offer_service.SearchOffersRequest) bool {
+	if len(in.OfferCodes) == 0 || in.Sort != nil {
+		return false
+	}
+	if in.Pagination == nil {
+		return true
+	}
+	return (in.Pagination.Offset == nil || *in.Pagination.Offset == 0) &&
+		(in.Pagination.Limit == nil || int(*in.Pagination.Limit) >= len(lo.Uniq(in.OfferCodes)))
+}
@@ -0,0 +1,93 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/samber/lo"
+	"gitlab.int.tsum.com/preowned/simona/delta/core.git/retrying_consumer"
+	"offer-read-service/internal/model"
+	"offer-read-service/internal/repository"
+)
+
+const (
+	skipReasonDuplicate = "duplicate"
+	skipReasonOutdated  = "outdated"
+)
+
+var skippedOffers = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "offer_read",
+	Subsystem: "consumer",
+	Name:      "skipped_offers_total",
+	Help:      "Number of offers not refreshed because they already reflect the event or a later one.",
+}, []string{"reason"})
+
+type reapplyKey struct{}
+
+// reapply marks the context of a deliberate reprocessing, e.g. a topic replay, which refreshes the offers
+// even if they already reflect the event.
+func reapply(ctx context.Context) context.Context {
+	return context.WithValue(ctx, reapplyKey{}, true)
+}
+
+func isReapply(ctx context.Context) bool {
+	reapply, _ := ctx.Value(reapplyKey{}).(bool)
+	return reapply
+}
+
+// eventID returns the id carried by the event message. Events without it are identified by the position
+// of the message, so the redelivery of the same message gets the same id and equal events in different
+// messages do not. Replayed events have neither and get an empty id, they are checked by their time only.
+func eventID(event any, meta retrying_consumer.Meta) string {
+	switch e := event.(type) {
+	case interface{ GetEventId() string }:
+		if id := e.GetEventId(); id != "" {
+			return id
+		}
+	case interface{ GetId() string }:
+		if id := e.GetId(); id != "" {
+			return id
+		}
+	}
+	if meta.Topic == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/%d/%d", meta.Topic, meta.Partition, meta.Offset)
+}
+
+// pendingOfferCodes drops the offers which already applied the event or an event created after it.
+func pendingOfferCodes(ctx context.Context, offerRepository repository.OfferRepository, offerCodes []string, event model.AppliedEvent) ([]string, error) {
+	if isReapply(ctx) {
+		return offerCodes, nil
+	}
+	var pending []string
+	for _, chunk := range lo.Chunk(offerCodes, itemOffersPageSize) {
+		lastEvents, err := offerRepository.LastEvents(ctx, chunk)
+		if err != nil {
+			return nil, fmt.Errorf("offerRepository.LastEvents %w", err)
+		}
+		for _, offerCode := range chunk {
+			last, ok := lastEvents[offerCode]
+			switch {
+			case !ok:
+				pending = append(pending, offerCode)
+			case event.ID != "" && last.ID == event.ID:
+				skippedOffers.WithLabelValues(skipReasonDuplicate).Inc()
+			case last.Time.After(event.Time):
+				skippedOffers.WithLabelValues(skipReasonOutdated).Inc()
+			default:
+				pending = append(pending, offerCode)
+			}
+		}
+	}
+	return pending, nil
+}
+
+func appliedEvents(offerCodes []string, event model.AppliedEvent) []model.AppliedEvent {
+	return lo.Map(offerCodes, func(offerCode string, _ int) model.AppliedEvent {
+		event.OfferCode = offerCode
+		return event
+	})
+}
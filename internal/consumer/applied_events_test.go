@@ -0,0 +1,72 @@
+package consumer
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/stock"
+	"gitlab.int.tsum.com/preowned/simona/delta/core.git/retrying_consumer"
+
+	"offer-read-service/internal/model"
+	"offer-read-service/internal/repository"
+)
+
+type lastEventsRepository struct {
+	repository.OfferRepository
+	lastEvents map[string]model.AppliedEvent
+}
+
+func (r lastEventsRepository) LastEvents(context.Context, []string) (map[string]model.AppliedEvent, error) {
+	return r.lastEvents, nil
+}
+
+func Test_pendingOfferCodes(t *testing.T) {
+	now := time.Now()
+	repo := lastEventsRepository{lastEvents: map[string]model.AppliedEvent{
+		"DUPLICATE": {OfferCode: "DUPLICATE", ID: "EVENT-1", Time: now},
+		"OUTDATED":  {OfferCode: "OUTDATED", ID: "EVENT-0", Time: now.Add(time.Minute)},
+		"EARLIER":   {OfferCode: "EARLIER", ID: "EVENT-0", Time: now.Add(-time.Minute)},
+	}}
+	offerCodes := []string{"DUPLICATE", "OUTDATED", "EARLIER", "NEW"}
+	event := model.AppliedEvent{ID: "EVENT-1", Time: now}
+	tests := []struct {
+		name  string
+		ctx   context.Context
+		event model.AppliedEvent
+		want  []string
+	}{
+		{name: "consumed", ctx: context.Background(), event: event, want: []string{"EARLIER", "NEW"}},
+		{name: "replayed", ctx: reapply(context.Background()), event: event, want: offerCodes},
+		{name: "without_id", ctx: context.Background(), event: model.AppliedEvent{Time: now}, want: []string{"DUPLICATE", "EARLIER", "NEW"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := pendingOfferCodes(tt.ctx, repo, offerCodes, tt.event)
+			if err != nil {
+				t.Fatalf("pendingOfferCodes() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pendingOfferCodes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_eventID(t *testing.T) {
+	meta := retrying_consumer.Meta{Topic: "stock-unit-sold", Partition: 1, Offset: 10}
+	event := &stock.StockUnitSoldEvent{OfferCode: "OFFER-CODE-1"}
+	first := eventID(event, meta)
+	redelivered := eventID(&stock.StockUnitSoldEvent{OfferCode: "OFFER-CODE-1"}, meta)
+	next := eventID(&stock.StockUnitSoldEvent{OfferCode: "OFFER-CODE-1"}, retrying_consumer.Meta{Topic: "stock-unit-sold", Partition: 1, Offset: 11})
+	if first != redelivered {
+		t.Errorf("eventID() of the redelivered message = %v and %v", first, redelivered)
+	}
+	if first == next {
+		t.Errorf("eventID() of equal events in different messages are equal")
+	}
+	if id := eventID(event, retrying_consumer.Meta{}); id != "" {
+		t.Errorf("eventID() without the message position = %v, want empty", id)
+	}
+}
@@ -0,0 +1,148 @@
+package consumer
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+)
+
+var batchSize = promauto.NewHistogram(prometheus.HistogramOpts{
+	Namespace: "offer_read",
+	Subsystem: "consumer",
+	Name:      "batch_size",
+	Help:      "Number of distinct offers refreshed in one batch.",
+	Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
+})
+
+type batch struct {
+	offerCodes []string
+	eventTimes map[string]time.Time
+	done       chan struct{}
+	err        error
+}
+
+// Batcher refreshes the offers of the events with one call per batch.
+//
+// With a window the offers are stored in the delay queue and refreshed by it once the window has passed,
+// so the events handled one after another are refreshed together. Submit returns once the offers are stored,
+// the stored refreshes survive a restart after the offsets of their events are committed.
+//
+// Without a window the offers submitted while a batch is written are collected into the next one, so only
+// concurrently handled events share a refresh. Submit returns only after the batch is written, so the offsets
+// of the events are committed after their offers are indexed.
+//
+// Offers whose status does not reflect the event yet are handed over to the delay queue for a recheck.
+type Batcher struct {
+	topic   string
+	window  time.Duration
+	maxSize int
+	queue   *DelayQueue
+
+	mu      sync.Mutex
+	pending []*batch
+	ready   chan struct{}
+}
+
+func NewBatcher(topic string, window time.Duration, maxSize int, queue *DelayQueue) *Batcher {
+	return &Batcher{
+		topic:   topic,
+		window:  window,
+		maxSize: maxSize,
+		queue:   queue,
+		ready:   make(chan struct{}, 1),
+	}
+}
+
+// Submit returns once all the offers are written or, with a window, stored for the refresh.
+// Offers beyond maxSize go to the following batches.
+func (b *Batcher) Submit(ctx context.Context, eventTime time.Time, offerCodes ...string) error {
+	if b.window > 0 {
+		return b.queue.Collect(ctx, lo.SliceToMap(offerCodes, func(offerCode string) (string, time.Time) {
+			return offerCode, eventTime
+		}), b.window)
+	}
+
+	var batches []*batch
+	b.mu.Lock()
+	for _, offerCode := range offerCodes {
+		if len(b.pending) == 0 || len(b.pending[len(b.pending)-1].offerCodes) >= b.maxSize {
+			b.pending = append(b.pending, &batch{eventTimes: map[string]time.Time{}, done: make(chan struct{})})
+		}
+		current := b.pending[len(b.pending)-1]
+		if earliest, ok := current.eventTimes[offerCode]; !ok {
+			current.eventTimes[offerCode] = eventTime
+			current.offerCodes = append(current.offerCodes, offerCode)
+		} else if eventTime.Before(earliest) {
+			current.eventTimes[offerCode] = eventTime
+		}
+		if len(batches) == 0 || batches[len(batches)-1] != current {
+			batches = append(batches, current)
+		}
+	}
+	b.mu.Unlock()
+
+	select {
+	case b.ready <- struct{}{}:
+	default:
+	}
+
+	for _, current := range batches {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-current.done:
+			if current.err != nil {
+				return current.err
+			}
+		}
+	}
+	return nil
+}
+
+// next takes the oldest collected batch, nil if there is none.
+func (b *Batcher) next() *batch {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.pending) == 0 {
+		return nil
+	}
+	current := b.pending[0]
+	b.pending[0] = nil
+	b.pending = b.pending[1:]
+	return current
+}
+
+// Run writes the collected batches one by one until the context is done.
+func (b *Batcher) Run(ctx context.Context, fn RefreshFunc) {
+	logger := ctxzap.Extract(ctx)
+	for {
+		current := b.next()
+		if current == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-b.ready:
+			}
+			continue
+		}
+
+		batchSize.Observe(float64(len(current.offerCodes)))
+		indexed, recheck, err := fn(ctx, current.offerCodes)
+		if err != nil {
+			logger.Error("batch refresh failed", zap.Int("size", len(current.offerCodes)), zap.Error(err))
+		}
+		observeFreshness(b.topic, indexed, recheck, current.eventTimes)
+		// the events of the batch are acknowledged only once their rechecks are stored
+		if scheduleErr := b.queue.Schedule(ctx, lo.PickByKeys(current.eventTimes, recheck)); scheduleErr != nil && err == nil {
+			err = scheduleErr
+		}
+		current.err = err
+		close(current.done)
+	}
+}
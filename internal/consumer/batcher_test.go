@@ -0,0 +1,154 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"offer-read-service/internal/model"
+)
+
+func TestBatcher_Submit(t *testing.T) {
+	tests := []struct {
+		name        string
+		offerCodes  []string
+		maxSize     int
+		err         error
+		wantBatches [][]string
+		wantRecheck int
+	}{
+		{
+			name:        "coalesced",
+			offerCodes:  []string{"OFFER-CODE-1", "OFFER-CODE-2", "OFFER-CODE-1", "OFFER-CODE-3"},
+			maxSize:     10,
+			wantBatches: [][]string{{"OFFER-CODE-0"}, {"OFFER-CODE-1", "OFFER-CODE-2", "OFFER-CODE-3"}},
+			wantRecheck: 1,
+		},
+		{
+			name:        "split_by_size",
+			offerCodes:  []string{"OFFER-CODE-1", "OFFER-CODE-2", "OFFER-CODE-3"},
+			maxSize:     2,
+			wantBatches: [][]string{{"OFFER-CODE-0"}, {"OFFER-CODE-1", "OFFER-CODE-2"}, {"OFFER-CODE-3"}},
+			wantRecheck: 1,
+		},
+		{
+			name:        "failed",
+			offerCodes:  []string{"OFFER-CODE-1"},
+			maxSize:     10,
+			err:         errors.New("update failed"),
+			wantBatches: [][]string{{"OFFER-CODE-0"}, {"OFFER-CODE-1"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			queue := NewDelayQueue("topic", time.Minute, 3, 10, nil)
+			b := NewBatcher("topic", 0, tt.maxSize, queue)
+
+			// the first batch is written until all the other offers are submitted
+			writing, release := make(chan struct{}), make(chan struct{})
+			var mu sync.Mutex
+			var batches [][]string
+			go b.Run(ctx, func(ctx context.Context, offerCodes []string) ([]model.Offer, []string, error) {
+				if offerCodes[0] == "OFFER-CODE-0" {
+					close(writing)
+					<-release
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				batch := append([]string(nil), offerCodes...)
+				sort.Strings(batch)
+				batches = append(batches, batch)
+				if tt.err != nil && offerCodes[0] != "OFFER-CODE-0" {
+					return nil, nil, tt.err
+				}
+				return nil, []string{"OFFER-CODE-1"}, nil
+			})
+
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_ = b.Submit(ctx, time.Now(), "OFFER-CODE-0")
+			}()
+			<-writing
+			for _, offerCode := range tt.offerCodes {
+				wg.Add(1)
+				go func(offerCode string) {
+					defer wg.Done()
+					if err := b.Submit(ctx, time.Now(), offerCode); !errors.Is(err, tt.err) {
+						t.Errorf("Submit() error = %v, want %v", err, tt.err)
+					}
+				}(offerCode)
+				// keeps the order of submits for the size split
+				time.Sleep(5 * time.Millisecond)
+			}
+			close(release)
+			wg.Wait()
+
+			if !reflect.DeepEqual(batches, tt.wantBatches) {
+				t.Errorf("batches = %v, want %v", batches, tt.wantBatches)
+			}
+			if got := queue.Len(); got != tt.wantRecheck {
+				t.Errorf("rechecks = %v, want %v", got, tt.wantRecheck)
+			}
+		})
+	}
+}
+
+func TestBatcher_Submit_single(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	b := NewBatcher("topic", 0, 10, NewDelayQueue("topic", time.Minute, 3, 10, nil))
+	go b.Run(ctx, func(ctx context.Context, offerCodes []string) ([]model.Offer, []string, error) {
+		return nil, nil, nil
+	})
+
+	// the events handled one by one are refreshed right away
+	for i := 0; i < 3; i++ {
+		if err := b.Submit(ctx, time.Now(), "OFFER-CODE-1"); err != nil {
+			t.Fatalf("Submit() error = %v", err)
+		}
+	}
+}
+
+func TestBatcher_Submit_window(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	queue := NewDelayQueue("topic", time.Minute, 3, 10, nil)
+	b := NewBatcher("topic", 50*time.Millisecond, 10, queue)
+	refreshes := make(chan []string, 10)
+	go queue.Run(ctx, func(ctx context.Context, offerCodes []string) ([]model.Offer, []string, error) {
+		refreshes <- offerCodes
+		return nil, nil, nil
+	})
+
+	// a burst of events handled one by one is refreshed with one call
+	offerCodes := []string{"OFFER-CODE-1", "OFFER-CODE-2", "OFFER-CODE-1", "OFFER-CODE-3", "OFFER-CODE-4"}
+	for _, offerCode := range offerCodes {
+		if err := b.Submit(ctx, time.Now(), offerCode); err != nil {
+			t.Fatalf("Submit() error = %v", err)
+		}
+	}
+
+	var got []string
+	select {
+	case got = <-refreshes:
+	case <-ctx.Done():
+		t.Fatalf("offers were not refreshed")
+	}
+	sort.Strings(got)
+	if want := []string{"OFFER-CODE-1", "OFFER-CODE-2", "OFFER-CODE-3", "OFFER-CODE-4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("refreshed = %v, want %v", got, want)
+	}
+	select {
+	case more := <-refreshes:
+		t.Errorf("burst refreshed with more than one call, next = %v", more)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,167 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/segmentio/kafka-go"
+	"gitlab.int.tsum.com/core/libraries/corekit.git/kafka/broker"
+	"gitlab.int.tsum.com/preowned/simona/delta/core.git/retrying_consumer"
+	"go.uber.org/zap"
+)
+
+const (
+	deadLetterHeaderSource   = "source"
+	deadLetterHeaderError    = "error"
+	deadLetterHeaderAttempts = "attempts"
+	deadLetterHeaderFailedAt = "failed_at"
+
+	// replay stops once no message has been fetched for this long
+	replayIdleTimeout = 10 * time.Second
+)
+
+// DeadLetterWriter publishes events that exhausted their retries to the dead-letter topic.
+// The event is stored as JSON keyed by its offer code, so the events of an offer keep their order,
+// the source topic and the failure are stored in the headers.
+type DeadLetterWriter struct {
+	writer messageWriter
+	topic  string
+}
+
+type messageWriter interface {
+	WriteMessages(ctx context.Context, messages ...kafka.Message) error
+	Close() error
+}
+
+// NewDeadLetterWriter returns nil when the topic is empty, events are dropped after the retries then.
+func NewDeadLetterWriter(config broker.Config, topic string) *DeadLetterWriter {
+	if topic == "" {
+		return nil
+	}
+	return &DeadLetterWriter{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(config.Brokers...),
+			Topic:        topic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+		},
+		topic: topic,
+	}
+}
+
+func (w *DeadLetterWriter) Write(ctx context.Context, source, key string, event any, attempts int, cause error) error {
+	value, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("json.Marshal %w", err)
+	}
+	return w.write(ctx, source, []byte(key), value, attempts, cause)
+}
+
+func (w *DeadLetterWriter) write(ctx context.Context, source string, key, value []byte, attempts int, cause error) error {
+	err := w.writer.WriteMessages(ctx, kafka.Message{
+		Key:   key,
+		Value: value,
+		Headers: []kafka.Header{
+			{Key: deadLetterHeaderSource, Value: []byte(source)},
+			{Key: deadLetterHeaderError, Value: []byte(cause.Error())},
+			{Key: deadLetterHeaderAttempts, Value: []byte(strconv.Itoa(attempts))},
+			{Key: deadLetterHeaderFailedAt, Value: []byte(time.Now().UTC().Format(time.RFC3339))},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("writer.WriteMessages %w", err)
+	}
+	return nil
+}
+
+func (w *DeadLetterWriter) Close() error {
+	if w == nil {
+		return nil
+	}
+	return w.writer.Close()
+}
+
+// ReplayFunc handles the raw value of a dead-lettered event.
+type ReplayFunc func(ctx context.Context, value []byte) error
+
+// Replay decodes dead-lettered events back into T and passes them to the normal handler.
+func Replay[T any](handler retrying_consumer.Handler[T]) ReplayFunc {
+	return func(ctx context.Context, value []byte) error {
+		event, err := decodeEvent[T](value)
+		if err != nil {
+			return err
+		}
+		return handler(ctx, event, retrying_consumer.Meta{})
+	}
+}
+
+type ReplayResult struct {
+	NumReplayed int `json:"num_replayed"`
+	NumFailed   int `json:"num_failed"`
+}
+
+// ReplayDeadLetters reads the dead-letter topic with its own consumer group and passes every event to the
+// handler registered for its source topic. Events failing again are written back to the topic with the
+// increased attempt count. The replay stops when the topic is drained or when it reaches an event
+// dead-lettered after the replay started, the rest is left for the next replay.
+func ReplayDeadLetters(ctx context.Context, config broker.Config, groupID string, deadLetters *DeadLetterWriter, handlers map[string]ReplayFunc) (ReplayResult, error) {
+	logger := ctxzap.Extract(ctx)
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     config.Brokers,
+		GroupID:     groupID,
+		Topic:       deadLetters.topic,
+		StartOffset: kafka.FirstOffset,
+	})
+	defer reader.Close()
+
+	started := time.Now()
+	result := ReplayResult{}
+	for {
+		fetchCtx, cancel := context.WithTimeout(ctx, replayIdleTimeout)
+		message, err := reader.FetchMessage(fetchCtx)
+		cancel()
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+			return result, nil
+		}
+		if err != nil {
+			return result, fmt.Errorf("reader.FetchMessage %w", err)
+		}
+		if message.Time.After(started) {
+			return result, nil
+		}
+
+		source := header(message, deadLetterHeaderSource)
+		err = fmt.Errorf("no replay handler for source %q", source)
+		if handler, ok := handlers[source]; ok {
+			err = handler(ctx, message.Value)
+		}
+		if err != nil {
+			result.NumFailed++
+			attempts, _ := strconv.Atoi(header(message, deadLetterHeaderAttempts))
+			logger.Error("dead letter replay failed", zap.String("source", source), zap.Int("attempts", attempts), zap.Error(err))
+			if err = deadLetters.write(ctx, source, message.Key, message.Value, attempts+1, err); err != nil {
+				return result, err
+			}
+		} else {
+			result.NumReplayed++
+		}
+
+		if err = reader.CommitMessages(ctx, message); err != nil {
+			return result, fmt.Errorf("reader.CommitMessages %w", err)
+		}
+	}
+}
+
+func header(message kafka.Message, key string) string {
+	for _, h := range message.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,142 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"gitlab.int.tsum.com/core/libraries/corekit.git/kafka/broker"
+	"gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/stock"
+	"gitlab.int.tsum.com/preowned/simona/delta/core.git/custom_error"
+	"gitlab.int.tsum.com/preowned/simona/delta/core.git/retrying_consumer"
+)
+
+type memoryMessageWriter struct {
+	messages []kafka.Message
+	err      error
+}
+
+func (w *memoryMessageWriter) WriteMessages(_ context.Context, messages ...kafka.Message) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.messages = append(w.messages, messages...)
+	return nil
+}
+
+func (w *memoryMessageWriter) Close() error {
+	return nil
+}
+
+func TestReplay(t *testing.T) {
+	value, err := json.Marshal(&stock.StockUnitReservedEvent{OfferCode: "OFFER-CODE-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got string
+	replay := Replay(func(_ context.Context, event stock.StockUnitReservedEvent, _ retrying_consumer.Meta) error {
+		got = event.OfferCode
+		return nil
+	})
+	if err = replay(context.Background(), value); err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	if got != "OFFER-CODE-1" {
+		t.Errorf("Replay() offer code = %v, want OFFER-CODE-1", got)
+	}
+	if err = replay(context.Background(), []byte("not json")); err == nil {
+		t.Errorf("Replay() of malformed value succeeded")
+	}
+}
+
+func TestRetryingMessageHandler_deadLetter(t *testing.T) {
+	tests := []struct {
+		name         string
+		handlerErr   error
+		writerErr    error
+		wantErr      bool
+		wantAttempts string
+	}{
+		{name: "permanent", handlerErr: &custom_error.InvalidArgument{Message: "bad"}, wantAttempts: "1"},
+		{name: "retries_exhausted", handlerErr: errors.New("boom"), wantAttempts: "3"},
+		{name: "write_failed", handlerErr: errors.New("boom"), writerErr: errors.New("kafka is down"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writer := &memoryMessageWriter{err: tt.writerErr}
+			deadLetters := &DeadLetterWriter{writer: writer, topic: "dead-letters"}
+			handler := RetryingMessageHandler(
+				func(context.Context, stock.StockUnitSoldEvent, retrying_consumer.Meta) error { return tt.handlerErr },
+				RetryPolicy{Attempts: 3, Delay: time.Millisecond},
+				nil,
+				deadLetters,
+				func(event stock.StockUnitSoldEvent) string { return event.OfferCode },
+				"stock-unit-sold",
+			)
+
+			err := handler(context.Background(), stock.StockUnitSoldEvent{OfferCode: "OFFER-CODE-1"}, retrying_consumer.Meta{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("handler() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(writer.messages) != 1 {
+				t.Fatalf("dead letters = %d, want 1", len(writer.messages))
+			}
+			message := writer.messages[0]
+			if string(message.Key) != "OFFER-CODE-1" {
+				t.Errorf("dead letter key = %q, want OFFER-CODE-1", message.Key)
+			}
+			if got := header(message, deadLetterHeaderSource); got != "stock-unit-sold" {
+				t.Errorf("dead letter source = %q, want stock-unit-sold", got)
+			}
+			if got := header(message, deadLetterHeaderAttempts); got != tt.wantAttempts {
+				t.Errorf("dead letter attempts = %q, want %q", got, tt.wantAttempts)
+			}
+			var event stock.StockUnitSoldEvent
+			if err = json.Unmarshal(message.Value, &event); err != nil || event.OfferCode != "OFFER-CODE-1" {
+				t.Errorf("dead letter value = %s, error = %v", message.Value, err)
+			}
+		})
+	}
+}
+
+func TestRetryingMessageHandler_withoutDeadLetters(t *testing.T) {
+	handler := RetryingMessageHandler(
+		func(context.Context, stock.StockUnitSoldEvent, retrying_consumer.Meta) error {
+			return &custom_error.InvalidArgument{Message: "bad"}
+		},
+		RetryPolicy{Attempts: 3, Delay: time.Millisecond},
+		nil,
+		NewDeadLetterWriter(broker.Config{}, ""),
+		func(event stock.StockUnitSoldEvent) string { return event.OfferCode },
+		"stock-unit-sold",
+	)
+	if err := handler(context.Background(), stock.StockUnitSoldEvent{OfferCode: "OFFER-CODE-1"}, retrying_consumer.Meta{}); err != nil {
+		t.Errorf("handler() error = %v, want the event dropped", err)
+	}
+}
+
+func TestRetryingMessageHandler_cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	handler := RetryingMessageHandler(
+		func(context.Context, stock.StockUnitSoldEvent, retrying_consumer.Meta) error {
+			t.Errorf("handler called with a cancelled context")
+			return nil
+		},
+		RetryPolicy{Attempts: 3, Delay: time.Millisecond},
+		nil,
+		nil,
+		func(event stock.StockUnitSoldEvent) string { return event.OfferCode },
+		"stock-unit-sold",
+	)
+	if err := handler(ctx, stock.StockUnitSoldEvent{OfferCode: "OFFER-CODE-1"}, retrying_consumer.Meta{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("handler() error = %v, want %v", err, context.Canceled)
+	}
+}
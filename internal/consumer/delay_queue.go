@@ -0,0 +1,288 @@
+package consumer
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+	"offer-read-service/internal/model"
+	"offer-read-service/internal/repository"
+)
+
+var delayQueueSize = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: "offer_read",
+	Subsystem: "consumer",
+	Name:      "delayed_refreshes",
+	Help:      "Number of offer refreshes waiting in the delay queue.",
+})
+
+type DelayedRefresh struct {
+	OfferCode string
+	// EventTime is the time of the earliest event waiting for the refresh.
+	EventTime time.Time
+	DueAt     time.Time
+	// Attempt is 0 for the first refresh and grows with every recheck or retry.
+	Attempt int
+}
+
+// RefreshFunc reindexes the offers and returns the indexed offers and the codes which should be rechecked later.
+type RefreshFunc func(ctx context.Context, offerCodes []string) ([]model.Offer, []string, error)
+
+// recheckLoadLimit bounds the number of stored rechecks loaded on start.
+const recheckLoadLimit = 10000
+
+// DelayQueue runs offer refreshes after a delay without blocking the consumer.
+// Rechecks are stored before the events which planned them are acknowledged and are loaded again on start,
+// so a restart doesn't lose them once the offsets are committed. Every pod consuming the topic loads all
+// its stored rechecks, a recheck may thus run twice, which is harmless.
+type DelayQueue struct {
+	topic        string
+	delay        time.Duration
+	maxAttempts  int
+	maxBatchSize int
+	rechecks     repository.RecheckRepository
+
+	mu        sync.Mutex
+	items     delayedRefreshes
+	scheduled map[string]*delayedRefresh
+	wakeup    chan struct{}
+}
+
+// NewDelayQueue keeps the rechecks in memory only if rechecks is nil.
+func NewDelayQueue(topic string, delay time.Duration, maxAttempts, maxBatchSize int, rechecks repository.RecheckRepository) *DelayQueue {
+	return &DelayQueue{
+		topic:        topic,
+		delay:        delay,
+		maxAttempts:  maxAttempts,
+		maxBatchSize: maxBatchSize,
+		rechecks:     rechecks,
+		scheduled:    map[string]*delayedRefresh{},
+		wakeup:       make(chan struct{}, 1),
+	}
+}
+
+// Schedule stores and plans the refreshes of the offers at the delay after their event times. An offer already
+// waiting keeps a single refresh at the latest of the due times, so bursts of events lead to one refresh.
+func (q *DelayQueue) Schedule(ctx context.Context, eventTimes map[string]time.Time) error {
+	refreshes := make([]DelayedRefresh, 0, len(eventTimes))
+	for offerCode, eventTime := range eventTimes {
+		refreshes = append(refreshes, DelayedRefresh{OfferCode: offerCode, EventTime: eventTime, DueAt: eventTime.Add(q.delay)})
+	}
+	if err := q.save(ctx, refreshes); err != nil {
+		return err
+	}
+	for _, refresh := range refreshes {
+		q.schedule(refresh, true)
+	}
+	return nil
+}
+
+// Collect stores and plans the first refreshes of the offers after the window. Unlike Schedule an offer already
+// waiting keeps the earlier due time, so a steady stream of events can't postpone its refresh beyond the window.
+func (q *DelayQueue) Collect(ctx context.Context, eventTimes map[string]time.Time, window time.Duration) error {
+	dueAt := time.Now().Add(window)
+	refreshes := make([]DelayedRefresh, 0, len(eventTimes))
+	for offerCode, eventTime := range eventTimes {
+		refreshes = append(refreshes, DelayedRefresh{OfferCode: offerCode, EventTime: eventTime, DueAt: dueAt})
+	}
+	if err := q.save(ctx, refreshes); err != nil {
+		return err
+	}
+	for _, refresh := range refreshes {
+		q.schedule(refresh, false)
+	}
+	return nil
+}
+
+func (q *DelayQueue) save(ctx context.Context, refreshes []DelayedRefresh) error {
+	if q.rechecks == nil || len(refreshes) == 0 {
+		return nil
+	}
+	err := q.rechecks.Save(ctx, lo.Map(refreshes, func(item DelayedRefresh, _ int) model.Recheck {
+		return model.Recheck{Topic: q.topic, OfferCode: item.OfferCode, EventTime: item.EventTime, DueAt: item.DueAt, Attempt: item.Attempt}
+	}))
+	if err != nil {
+		return fmt.Errorf("rechecks.Save %w", err)
+	}
+	return nil
+}
+
+func (q *DelayQueue) delete(ctx context.Context, offerCodes []string) {
+	if q.rechecks == nil || len(offerCodes) == 0 {
+		return
+	}
+	if err := q.rechecks.Delete(ctx, q.topic, offerCodes); err != nil {
+		ctxzap.Error(ctx, "can't delete rechecks", zap.Strings("offer_codes", offerCodes), zap.Error(err))
+	}
+}
+
+// load plans the rechecks stored before the restart.
+func (q *DelayQueue) load(ctx context.Context) {
+	if q.rechecks == nil {
+		return
+	}
+	rechecks, err := q.rechecks.List(ctx, q.topic, recheckLoadLimit)
+	if err != nil {
+		ctxzap.Error(ctx, "can't load rechecks", zap.Error(err))
+		return
+	}
+	for _, recheck := range rechecks {
+		q.schedule(DelayedRefresh{OfferCode: recheck.OfferCode, EventTime: recheck.EventTime, DueAt: recheck.DueAt, Attempt: recheck.Attempt}, true)
+	}
+}
+
+// schedule plans the refresh, a refresh of the offer already waiting is moved to the later due time
+// if postpone is set and to the earlier one otherwise.
+func (q *DelayQueue) schedule(refresh DelayedRefresh, postpone bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if item, ok := q.scheduled[refresh.OfferCode]; ok {
+		if refresh.EventTime.Before(item.EventTime) {
+			item.EventTime = refresh.EventTime
+		}
+		if postpone && refresh.DueAt.After(item.DueAt) || !postpone && refresh.DueAt.Before(item.DueAt) {
+			item.DueAt = refresh.DueAt
+			heap.Fix(&q.items, item.index)
+		}
+		return
+	}
+	item := &delayedRefresh{DelayedRefresh: refresh}
+	heap.Push(&q.items, item)
+	q.scheduled[refresh.OfferCode] = item
+	delayQueueSize.Set(float64(len(q.items)))
+
+	select {
+	case q.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+func (q *DelayQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+// next returns up to maxBatchSize due refreshes, otherwise the time to wait for the earliest one.
+func (q *DelayQueue) next(now time.Time) ([]DelayedRefresh, time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.items) == 0 {
+		return nil, -1
+	}
+	if wait := q.items[0].DueAt.Sub(now); wait > 0 {
+		return nil, wait
+	}
+	var due []DelayedRefresh
+	for len(q.items) > 0 && !q.items[0].DueAt.After(now) && len(due) < q.maxBatchSize {
+		item := heap.Pop(&q.items).(*delayedRefresh)
+		delete(q.scheduled, item.OfferCode)
+		due = append(due, item.DelayedRefresh)
+	}
+	delayQueueSize.Set(float64(len(q.items)))
+	return due, 0
+}
+
+// Run processes due refreshes in batches until the context is done. Failed refreshes and the ones
+// asking for a recheck are scheduled again after the delay until maxAttempts is reached.
+func (q *DelayQueue) Run(ctx context.Context, fn RefreshFunc) {
+	logger := ctxzap.Extract(ctx)
+	q.load(ctx)
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		due, wait := q.next(time.Now())
+		if len(due) == 0 {
+			if wait >= 0 {
+				timer.Reset(wait)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-q.wakeup:
+			case <-timer.C:
+			}
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			continue
+		}
+
+		offerCodes := lo.Map(due, func(item DelayedRefresh, _ int) string {
+			return item.OfferCode
+		})
+		indexed, recheck, err := fn(ctx, offerCodes)
+		if err != nil {
+			logger.Error("delayed refresh failed", zap.Strings("offer_codes", offerCodes), zap.Error(err))
+			recheck = offerCodes
+		}
+		refreshes := lo.KeyBy(due, func(item DelayedRefresh) string {
+			return item.OfferCode
+		})
+		observeFreshness(q.topic, indexed, recheck, lo.MapValues(refreshes, func(item DelayedRefresh, _ string) time.Time {
+			return item.EventTime
+		}))
+		var again []DelayedRefresh
+		for _, offerCode := range recheck {
+			refresh := refreshes[offerCode]
+			if refresh.Attempt+1 >= q.maxAttempts {
+				logger.Error("delayed refresh attempts exhausted", zap.String("offer_code", offerCode))
+				continue
+			}
+			again = append(again, DelayedRefresh{
+				OfferCode: offerCode,
+				EventTime: refresh.EventTime,
+				DueAt:     time.Now().Add(q.delay),
+				Attempt:   refresh.Attempt + 1,
+			})
+		}
+		if err = q.save(ctx, again); err != nil {
+			logger.Error("can't store rechecks", zap.Error(err))
+		}
+		for _, refresh := range again {
+			q.schedule(refresh, true)
+		}
+		q.delete(ctx, lo.Without(offerCodes, lo.Map(again, func(item DelayedRefresh, _ int) string {
+			return item.OfferCode
+		})...))
+	}
+}
+
+type delayedRefresh struct {
+	DelayedRefresh
+	index int
+}
+
+type delayedRefreshes []*delayedRefresh
+
+func (h delayedRefreshes) Len() int           { return len(h) }
+func (h delayedRefreshes) Less(i, j int) bool { return h[i].DueAt.Before(h[j].DueAt) }
+func (h delayedRefreshes) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *delayedRefreshes) Push(x any) {
+	item := x.(*delayedRefresh)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *delayedRefreshes) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return item
+}
@@ -0,0 +1,150 @@
+package consumer
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"offer-read-service/internal/model"
+	"offer-read-service/internal/repository"
+)
+
+func TestDelayQueue_next(t *testing.T) {
+	now := time.Now()
+	q := NewDelayQueue("topic", time.Second, 1, 10, nil)
+	q.schedule(DelayedRefresh{OfferCode: "OFFER-CODE-2", DueAt: now.Add(time.Second)}, true)
+	q.schedule(DelayedRefresh{OfferCode: "OFFER-CODE-1", DueAt: now}, true)
+	q.schedule(DelayedRefresh{OfferCode: "OFFER-CODE-3", DueAt: now.Add(-time.Second), EventTime: now}, true)
+	// the second event postpones the pending refresh instead of adding one more and keeps the earliest event time
+	q.schedule(DelayedRefresh{OfferCode: "OFFER-CODE-3", DueAt: now.Add(2 * time.Second), EventTime: now.Add(time.Second)}, true)
+
+	if got := q.Len(); got != 3 {
+		t.Fatalf("Len() = %v, want 3", got)
+	}
+
+	due, _ := q.next(now.Add(5 * time.Second))
+	got := make([]string, 0, len(due))
+	for _, refresh := range due {
+		got = append(got, refresh.OfferCode)
+	}
+	if !due[2].EventTime.Equal(now) {
+		t.Errorf("next() event time = %v, want %v", due[2].EventTime, now)
+	}
+	want := []string{"OFFER-CODE-1", "OFFER-CODE-2", "OFFER-CODE-3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("next() order = %v, want %v", got, want)
+	}
+
+	q.schedule(DelayedRefresh{OfferCode: "OFFER-CODE-4", DueAt: now.Add(time.Second)}, true)
+	if due, wait := q.next(now); due != nil || wait != time.Second {
+		t.Errorf("next() = %v, %v, want nil, %v", due, wait, time.Second)
+	}
+}
+
+func TestDelayQueue_Collect(t *testing.T) {
+	q := NewDelayQueue("topic", time.Minute, 1, 10, nil)
+	if err := q.Collect(context.Background(), map[string]time.Time{"OFFER-CODE-1": time.Now()}, time.Second); err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+	// a later event keeps the first due time, so a stream of events can't postpone the refresh
+	if err := q.Collect(context.Background(), map[string]time.Time{"OFFER-CODE-1": time.Now()}, time.Hour); err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+	if due, _ := q.next(time.Now().Add(2 * time.Second)); len(due) != 1 {
+		t.Errorf("next() = %v, want the refresh due after the first window", due)
+	}
+}
+
+func TestDelayQueue_Run(t *testing.T) {
+	q := NewDelayQueue("topic", time.Millisecond, 3, 10, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var calls [][]string
+	eventTime := time.Now()
+	if err := q.Schedule(ctx, map[string]time.Time{"OFFER-CODE-1": eventTime, "OFFER-CODE-2": eventTime}); err != nil {
+		t.Fatalf("Schedule() error = %v", err)
+	}
+	q.Run(ctx, func(ctx context.Context, offerCodes []string) ([]model.Offer, []string, error) {
+		offerCodes = append([]string(nil), offerCodes...)
+		sort.Strings(offerCodes)
+		calls = append(calls, offerCodes)
+		if len(calls) == 3 {
+			cancel()
+		}
+		return nil, []string{"OFFER-CODE-2"}, nil
+	})
+
+	want := [][]string{{"OFFER-CODE-1", "OFFER-CODE-2"}, {"OFFER-CODE-2"}, {"OFFER-CODE-2"}}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+	if got := q.Len(); got != 0 {
+		t.Errorf("Len() = %v, want 0", got)
+	}
+}
+
+type memoryRecheckRepository struct {
+	repository.RecheckRepository
+	mu       sync.Mutex
+	rechecks map[string]model.Recheck
+}
+
+func (r *memoryRecheckRepository) Save(_ context.Context, rechecks []model.Recheck) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, recheck := range rechecks {
+		r.rechecks[recheck.OfferCode] = recheck
+	}
+	return nil
+}
+
+func (r *memoryRecheckRepository) List(_ context.Context, _ string, _ int) ([]model.Recheck, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var rechecks []model.Recheck
+	for _, recheck := range r.rechecks {
+		rechecks = append(rechecks, recheck)
+	}
+	return rechecks, nil
+}
+
+func (r *memoryRecheckRepository) Delete(_ context.Context, _ string, offerCodes []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, offerCode := range offerCodes {
+		delete(r.rechecks, offerCode)
+	}
+	return nil
+}
+
+func TestDelayQueue_restart(t *testing.T) {
+	rechecks := &memoryRecheckRepository{rechecks: map[string]model.Recheck{}}
+	eventTime := time.Now().Add(-time.Hour)
+	stopped := NewDelayQueue("topic", time.Minute, 3, 10, rechecks)
+	if err := stopped.Schedule(context.Background(), map[string]time.Time{"OFFER-CODE-1": eventTime}); err != nil {
+		t.Fatalf("Schedule() error = %v", err)
+	}
+	if got := rechecks.rechecks["OFFER-CODE-1"].DueAt; !got.Equal(eventTime.Add(time.Minute)) {
+		t.Errorf("stored due at = %v, want the event time plus the delay %v", got, eventTime.Add(time.Minute))
+	}
+
+	// the queue of the restarted consumer runs the stored recheck, which is due already, and drops it once settled
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var calls [][]string
+	NewDelayQueue("topic", time.Minute, 3, 10, rechecks).Run(ctx, func(ctx context.Context, offerCodes []string) ([]model.Offer, []string, error) {
+		calls = append(calls, offerCodes)
+		cancel()
+		return nil, nil, nil
+	})
+	if want := [][]string{{"OFFER-CODE-1"}}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+	if len(rechecks.rechecks) != 0 {
+		t.Errorf("stored rechecks = %v, want none", rechecks.rechecks)
+	}
+}
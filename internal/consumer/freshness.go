@@ -0,0 +1,61 @@
+package consumer
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"gitlab.int.tsum.com/preowned/simona/delta/core.git/retrying_consumer"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"offer-read-service/internal/model"
+)
+
+var (
+	handleDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "offer_read",
+		Subsystem: "consumer",
+		Name:      "handle_duration_seconds",
+		Help:      "Time spent handling one event including retries.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 14),
+	}, []string{"topic"})
+	freshness = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "offer_read",
+		Subsystem: "consumer",
+		Name:      "freshness_seconds",
+		Help:      "Time from the event to the index reflecting it (offer.indexed).",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 14),
+	}, []string{"topic"})
+)
+
+// eventTime returns the creation time carried by the event message, events without it are timed by their receipt,
+// which is the message timestamp.
+func eventTime(event any, received time.Time) time.Time {
+	if e, ok := event.(interface{ GetCreatedAt() *timestamppb.Timestamp }); ok && e.GetCreatedAt().IsValid() {
+		return e.GetCreatedAt().AsTime()
+	}
+	return received
+}
+
+// receivedAt is the time the message was written to the topic, replayed events without it are timed now.
+func receivedAt(meta retrying_consumer.Meta) time.Time {
+	if meta.Timestamp.IsZero() {
+		return time.Now()
+	}
+	return meta.Timestamp
+}
+
+// observeFreshness records the offers which reflect their events, the ones left for a recheck are recorded once settled.
+func observeFreshness(topic string, indexed []model.Offer, recheck []string, eventTimes map[string]time.Time) {
+	pending := make(map[string]struct{}, len(recheck))
+	for _, offerCode := range recheck {
+		pending[offerCode] = struct{}{}
+	}
+	for _, offer := range indexed {
+		if _, ok := pending[offer.Code]; ok {
+			continue
+		}
+		if eventTime, ok := eventTimes[offer.Code]; ok && !eventTime.IsZero() {
+			freshness.WithLabelValues(topic).Observe(offer.Indexed.Sub(eventTime).Seconds())
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/samber/lo"
+	"github.com/segmentio/kafka-go"
+	"gitlab.int.tsum.com/core/libraries/corekit.git/kafka/broker"
+	"go.uber.org/zap"
+)
+
+var consumerLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "offer_read",
+	Subsystem: "consumer",
+	Name:      "lag",
+	Help:      "Number of messages the consumer group is behind the end of the partition.",
+}, []string{"topic", "partition"})
+
+var consumerLagging = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: "offer_read",
+	Subsystem: "consumer",
+	Name:      "lagging",
+	Help:      "Whether the lag of any partition exceeds the threshold (1) or not (0).",
+})
+
+var lagCheckErrors = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: "offer_read",
+	Subsystem: "consumer",
+	Name:      "lag_check_errors_total",
+	Help:      "Number of failed consumer lag checks.",
+})
+
+type LagState struct {
+	MaxLag    int64     `json:"max_lag"`
+	Threshold int64     `json:"threshold"`
+	Lagging   bool      `json:"lagging"`
+	CheckedAt time.Time `json:"checked_at"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// LagMonitor periodically compares the committed offsets of the consumer group with the end of the topics.
+// By default the lag is only reported, the service still serves reads while it catches up.
+type LagMonitor struct {
+	client    *kafka.Client
+	groupID   string
+	topics    []string
+	threshold int64
+
+	mu       sync.Mutex
+	maxLag   int64
+	checked  time.Time
+	checkErr error
+}
+
+func NewLagMonitor(config broker.Config, groupID string, topics []string, threshold int64) *LagMonitor {
+	return &LagMonitor{
+		client:    &kafka.Client{Addr: kafka.TCP(config.Brokers...), Timeout: 10 * time.Second},
+		groupID:   groupID,
+		topics:    topics,
+		threshold: threshold,
+	}
+}
+
+func (m *LagMonitor) Run(ctx context.Context, interval time.Duration) {
+	logger := ctxzap.Extract(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		maxLag, err := m.check(ctx)
+		if err != nil {
+			logger.Warn("can't check consumer lag", zap.Error(err))
+			lagCheckErrors.Inc()
+		}
+		m.mu.Lock()
+		if err == nil {
+			m.maxLag = maxLag
+		}
+		m.checked, m.checkErr = time.Now(), err
+		consumerLagging.Set(lo.Ternary(m.lagging(), 1.0, 0.0))
+		m.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// State returns the result of the last check, a nil monitor reports no lag.
+func (m *LagMonitor) State() LagState {
+	if m == nil {
+		return LagState{}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state := LagState{MaxLag: m.maxLag, Threshold: m.threshold, Lagging: m.lagging(), CheckedAt: m.checked}
+	if m.checkErr != nil {
+		state.LastError = m.checkErr.Error()
+	}
+	return state
+}
+
+// Ready fails while the lag exceeds the threshold, a nil monitor is always ready.
+func (m *LagMonitor) Ready() error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.lagging() {
+		return fmt.Errorf("consumer lag %d exceeds %d", m.maxLag, m.threshold)
+	}
+	return nil
+}
+
+// lagging keeps the result of the last successful check when a check fails.
+func (m *LagMonitor) lagging() bool {
+	return m.threshold > 0 && m.maxLag > m.threshold
+}
+
+func (m *LagMonitor) check(ctx context.Context) (int64, error) {
+	metadata, err := m.client.Metadata(ctx, &kafka.MetadataRequest{Topics: m.topics})
+	if err != nil {
+		return 0, fmt.Errorf("client.Metadata %w", err)
+	}
+	partitions := map[string][]int{}
+	offsetRequests := map[string][]kafka.OffsetRequest{}
+	for _, topic := range metadata.Topics {
+		if topic.Error != nil {
+			return 0, fmt.Errorf("topic %s metadata %w", topic.Name, topic.Error)
+		}
+		for _, partition := range topic.Partitions {
+			partitions[topic.Name] = append(partitions[topic.Name], partition.ID)
+			offsetRequests[topic.Name] = append(offsetRequests[topic.Name], kafka.LastOffsetOf(partition.ID), kafka.FirstOffsetOf(partition.ID))
+		}
+	}
+
+	offsets, err := m.client.ListOffsets(ctx, &kafka.ListOffsetsRequest{Topics: offsetRequests})
+	if err != nil {
+		return 0, fmt.Errorf("client.ListOffsets %w", err)
+	}
+	committed, err := m.client.OffsetFetch(ctx, &kafka.OffsetFetchRequest{GroupID: m.groupID, Topics: partitions})
+	if err != nil {
+		return 0, fmt.Errorf("client.OffsetFetch %w", err)
+	}
+	if committed.Error != nil {
+		return 0, fmt.Errorf("client.OffsetFetch %w", committed.Error)
+	}
+
+	var maxLag int64
+	for topic, partitionOffsets := range offsets.Topics {
+		committedOffsets := map[int]int64{}
+		for _, partition := range committed.Topics[topic] {
+			committedOffsets[partition.Partition] = partition.CommittedOffset
+		}
+		for _, partition := range partitionOffsets {
+			if partition.Error != nil {
+				return 0, fmt.Errorf("topic %s partition %d offsets %w", topic, partition.Partition, partition.Error)
+			}
+			lag := partitionLag(partition, committedOffsets[partition.Partition])
+			consumerLag.WithLabelValues(topic, strconv.Itoa(partition.Partition)).Set(float64(lag))
+			if lag > maxLag {
+				maxLag = lag
+			}
+		}
+	}
+	return maxLag, nil
+}
+
+// partitionLag counts from the start of the partition when the group has not committed there yet (-1).
+func partitionLag(partition kafka.PartitionOffsets, committed int64) int64 {
+	if committed < 0 {
+		committed = partition.FirstOffset
+	}
+	if lag := partition.LastOffset - committed; lag > 0 {
+		return lag
+	}
+	return 0
+}
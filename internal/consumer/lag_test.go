@@ -0,0 +1,48 @@
+package consumer
+
+import (
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func Test_partitionLag(t *testing.T) {
+	tests := []struct {
+		name      string
+		partition kafka.PartitionOffsets
+		committed int64
+		want      int64
+	}{
+		{name: "caught_up", partition: kafka.PartitionOffsets{FirstOffset: 0, LastOffset: 100}, committed: 100, want: 0},
+		{name: "behind", partition: kafka.PartitionOffsets{FirstOffset: 0, LastOffset: 100}, committed: 40, want: 60},
+		{name: "not_committed", partition: kafka.PartitionOffsets{FirstOffset: 20, LastOffset: 100}, committed: -1, want: 80},
+		{name: "empty", partition: kafka.PartitionOffsets{FirstOffset: 5, LastOffset: 5}, committed: -1, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := partitionLag(tt.partition, tt.committed); got != tt.want {
+				t.Errorf("partitionLag() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLagMonitor_Ready(t *testing.T) {
+	tests := []struct {
+		name    string
+		monitor *LagMonitor
+		wantErr bool
+	}{
+		{name: "disabled", monitor: nil},
+		{name: "caught_up", monitor: &LagMonitor{threshold: 100, maxLag: 100}},
+		{name: "lagging", monitor: &LagMonitor{threshold: 100, maxLag: 101}, wantErr: true},
+		{name: "no_threshold", monitor: &LagMonitor{maxLag: 101}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.monitor.Ready(); (err != nil) != tt.wantErr {
+				t.Errorf("Ready() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/avast/retry-go"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/samber/lo"
+	"gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/catalog"
+	"gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/offer"
+	"gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/offer_service"
+	"gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/stock"
+	"gitlab.int.tsum.com/preowned/simona/delta/core.git/retrying_consumer"
+	"go.uber.org/zap"
+
+	"offer-read-service/internal/health"
+	"offer-read-service/internal/model"
+	"offer-read-service/internal/ratelimit"
+	"offer-read-service/internal/repository"
+	"offer-read-service/internal/service"
+)
+
+const itemOffersPageSize = 500
+
+// OfferCodesFunc maps an event to the codes of the offers it affects.
+type OfferCodesFunc[T any] func(ctx context.Context, event T) ([]string, error)
+
+// SettledFunc reports whether the indexed offer already reflects the event, nil means no recheck is needed.
+type SettledFunc func(offer model.Offer) bool
+
+// OfferEvent hands the affected offers over to the batcher and returns once the batch with them is indexed
+// or stored for the refresh. Offers which already reflect the event or a later one are skipped, the applied
+// event is stored alongside the offer once the batcher accepted it.
+func OfferEvent[T any](batcher *Batcher, offerCodes OfferCodesFunc[T], offerRepository repository.OfferRepository) retrying_consumer.Handler[T] {
+	return func(ctx context.Context, event T, meta retrying_consumer.Meta) error {
+		codes, err := offerCodes(ctx, event)
+		if err != nil {
+			return err
+		}
+		if len(codes) == 0 {
+			return nil
+		}
+
+		applied := model.AppliedEvent{ID: eventID(&event, meta), Time: eventTime(&event, receivedAt(meta))}
+		codes, err = pendingOfferCodes(ctx, offerRepository, lo.Uniq(codes), applied)
+		if err != nil {
+			return err
+		}
+		if len(codes) == 0 {
+			return nil
+		}
+
+		if err = batcher.Submit(ctx, applied.Time, codes...); err != nil {
+			return err
+		}
+		if err = offerRepository.MarkEventsApplied(ctx, appliedEvents(codes, applied)); err != nil {
+			return fmt.Errorf("offerRepository.MarkEventsApplied %w", err)
+		}
+		return nil
+	}
+}
+
+func StockUnitReserved(_ context.Context, event stock.StockUnitReservedEvent) ([]string, error) {
+	return []string{event.OfferCode}, nil
+}
+
+func StockUnitSold(_ context.Context, event stock.StockUnitSoldEvent) ([]string, error) {
+	return []string{event.OfferCode}, nil
+}
+
+func StockUnitReleased(_ context.Context, event stock.StockUnitReleasedEvent) ([]string, error) {
+	return []string{event.OfferCode}, nil
+}
+
+func StockUnitReturnedToSeller(_ context.Context, event stock.StockUnitReturnedToSellerEvent) ([]string, error) {
+	return []string{event.OfferCode}, nil
+}
+
+func OfferPriceChanged(_ context.Context, event offer.OfferPriceChangedEvent) ([]string, error) {
+	return []string{event.OfferCode}, nil
+}
+
+// ItemPublicationFlagsChanged affects every indexed offer of the item.
+func ItemPublicationFlagsChanged(offerRepository repository.OfferRepository) OfferCodesFunc[catalog.ItemPublicationFlagsChangedEvent] {
+	return func(ctx context.Context, event catalog.ItemPublicationFlagsChangedEvent) ([]string, error) {
+		var offerCodes []string
+		searchAfter := ""
+		for {
+			codes, err := offerRepository.ListOfferCodes(ctx, repository.OfferFilter{ItemCodes: []string{event.ItemCode}}, searchAfter, itemOffersPageSize)
+			if err != nil {
+				return nil, fmt.Errorf("offerRepository.ListOfferCodes %w", err)
+			}
+			offerCodes = append(offerCodes, codes...)
+			if len(codes) < itemOffersPageSize {
+				return offerCodes, nil
+			}
+			searchAfter = codes[len(codes)-1]
+		}
+	}
+}
+
+// Invalidating drops the upstream data cached for the event before the affected offers are refreshed.
+func Invalidating[T any](offerCodes OfferCodesFunc[T], invalidate func(event T)) OfferCodesFunc[T] {
+	return func(ctx context.Context, event T) ([]string, error) {
+		invalidate(event)
+		return offerCodes(ctx, event)
+	}
+}
+
+func StatusIn(statuses ...model.OfferStatusCode) SettledFunc {
+	return func(offer model.Offer) bool {
+		return lo.Contains(statuses, offer.Status)
+	}
+}
+
+func StatusNotIn(statuses ...model.OfferStatusCode) SettledFunc {
+	return func(offer model.Offer) bool {
+		return !lo.Contains(statuses, offer.Status)
+	}
+}
+
+// RefreshOffers reindexes the offers and returns the ones which are not settled yet.
+// Excluded offers are removed from the index and have no status to wait for, they are never rechecked.
+// The upstream calls share the rate limits with the indexing.
+func RefreshOffers(offerClient offer_service.OfferServiceClient, offerEnricher service.OfferEnricher, offerRepository repository.OfferRepository, settled SettledFunc) RefreshFunc {
+	return func(ctx context.Context, offerCodes []string) ([]model.Offer, []string, error) {
+		ctx = ratelimit.WithLimits(ctx)
+		searchOffers, err := offerClient.SearchOffers(ctx, &offer_service.SearchOffersRequest{
+			Pagination: &offer_service.Pagination{
+				Limit: lo.ToPtr(int32(len(offerCodes))),
+			},
+			OfferCodes: offerCodes,
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("offerClient.SearchOffers %w", err)
+		}
+		if len(searchOffers.Offer) == 0 {
+			ctxzap.Info(ctx, "offers not found", zap.Strings("offer_codes", offerCodes))
+			return nil, nil, nil
+		}
+		offers, err := offerEnricher.Enrich(ctx, searchOffers.Offer)
+		if err != nil {
+			return nil, nil, fmt.Errorf("offerEnricher.Enrich %w", err)
+		}
+		err = offerRepository.Update(ctx, offers)
+		if err != nil {
+			return nil, nil, fmt.Errorf("offerRepository.Update %w", err)
+		}
+		if settled == nil {
+			return offers, nil, nil
+		}
+		return offers, lo.FilterMap(offers, func(item model.Offer, _ int) (string, bool) {
+			return item.Code, !item.Excluded && !settled(item)
+		}), nil
+	}
+}
+
+// RetryingMessageHandler retries the event according to the policy and publishes it to the dead-letter topic
+// under the key of the event once the retries are exhausted or the error is permanent. Without the dead-letter
+// topic the event is only logged. Events interrupted by the shutdown are not acknowledged.
+// While the dependencies are unhealthy the handler waits for them to recover, which pauses the consumption,
+// and the events which failed because of them are retried from scratch instead of being dead-lettered.
+func RetryingMessageHandler[T any](under retrying_consumer.Handler[T], policy RetryPolicy, dependencies *health.Monitor, deadLetters *DeadLetterWriter, key func(event T) string, source string) retrying_consumer.Handler[T] {
+	return func(ctx context.Context, event T, meta retrying_consumer.Meta) error {
+		logger := ctxzap.Extract(ctx)
+		started := time.Now()
+		defer func() {
+			handleDuration.WithLabelValues(source).Observe(time.Since(started).Seconds())
+		}()
+
+		var attempts int
+		var err error
+		for {
+			if err = dependencies.Wait(ctx); err != nil {
+				return err
+			}
+			attempts, err = retryEvent(ctx, under, event, meta, policy)
+			// a context cancelled before the first attempt leaves no attempts at all
+			if attempts > 1 {
+				retriesTotal.WithLabelValues(source).Add(float64(attempts - 1))
+			}
+			if err == nil {
+				eventsTotal.WithLabelValues(source, outcomeSuccess).Inc()
+				return nil
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if IsPermanent(err) || dependencies.Healthy() == nil {
+				break
+			}
+			logger.Warn("event failed while dependencies are unhealthy, waiting for recovery", zap.Error(err))
+		}
+
+		outcome := outcomeRetriesExhausted
+		if IsPermanent(err) {
+			outcome = outcomePermanentFailure
+		}
+		logger.Error("final retry fail", zap.Error(err), zap.String("outcome", outcome), zap.Int("attempts", attempts), zap.Any("event", event))
+		if deadLetters == nil {
+			eventsTotal.WithLabelValues(source, outcome).Inc()
+			return nil
+		}
+		if err := deadLetters.Write(ctx, source, key(event), &event, attempts, err); err != nil {
+			eventsTotal.WithLabelValues(source, outcomeDeadLetterFailed).Inc()
+			logger.Error("can't write dead letter", zap.Error(err), zap.Any("event", event))
+			return err
+		}
+		eventsTotal.WithLabelValues(source, outcome).Inc()
+		return nil
+	}
+}
+
+// retryEvent returns the number of attempts and the error of the last one.
+func retryEvent[T any](ctx context.Context, under retrying_consumer.Handler[T], event T, meta retrying_consumer.Meta, policy RetryPolicy) (int, error) {
+	logger := ctxzap.Extract(ctx)
+	retryCtx, cancel := policy.context(ctx)
+	defer cancel()
+
+	attempts := 0
+	var lastErr error
+	err := retry.Do(
+		func() error {
+			attempts++
+			lastErr = under(retryCtx, event, meta)
+			return lastErr
+		},
+		policy.options(retryCtx, func(n uint, err error) {
+			logger.Error("retry attempt", zap.Uint("attempt", n), zap.Error(err))
+		})...,
+	)
+	if err != nil && lastErr != nil {
+		err = lastErr
+	}
+	return attempts, err
+}
@@ -0,0 +1,131 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/catalog"
+	"gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/offer_service"
+	"google.golang.org/grpc"
+
+	"offer-read-service/internal/model"
+	"offer-read-service/internal/repository"
+)
+
+type itemOfferRepository struct {
+	repository.OfferRepository
+	offerCodes []string
+}
+
+func (r itemOfferRepository) ListOfferCodes(_ context.Context, _ repository.OfferFilter, searchAfter string, size int) ([]string, error) {
+	start := sort.SearchStrings(r.offerCodes, searchAfter)
+	if start < len(r.offerCodes) && r.offerCodes[start] == searchAfter {
+		start++
+	}
+	end := start + size
+	if end > len(r.offerCodes) {
+		end = len(r.offerCodes)
+	}
+	return r.offerCodes[start:end], nil
+}
+
+func TestItemPublicationFlagsChanged(t *testing.T) {
+	tests := []struct {
+		name      string
+		numOffers int
+	}{
+		{name: "no_offers", numOffers: 0},
+		{name: "one_page", numOffers: 3},
+		{name: "exact_page", numOffers: itemOffersPageSize},
+		{name: "several_pages", numOffers: itemOffersPageSize*2 + 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			offerCodes := make([]string, 0, tt.numOffers)
+			for i := 0; i < tt.numOffers; i++ {
+				offerCodes = append(offerCodes, fmt.Sprintf("OFFER-CODE-%05d", i))
+			}
+			fn := ItemPublicationFlagsChanged(itemOfferRepository{offerCodes: offerCodes})
+
+			got, err := fn(context.Background(), catalog.ItemPublicationFlagsChangedEvent{ItemCode: "ITEM-CODE-1"})
+			if err != nil {
+				t.Fatalf("ItemPublicationFlagsChanged() error = %v", err)
+			}
+			if len(got) != len(offerCodes) || (len(got) > 0 && !reflect.DeepEqual(got, offerCodes)) {
+				t.Errorf("ItemPublicationFlagsChanged() got %v offers, want %v", len(got), len(offerCodes))
+			}
+		})
+	}
+}
+
+func TestSettledFunc(t *testing.T) {
+	tests := []struct {
+		name    string
+		settled SettledFunc
+		status  model.OfferStatusCode
+		want    bool
+	}{
+		{name: "reserved", settled: StatusIn(model.OfferStatusCodeInOrder, model.OfferStatusCodeSold), status: model.OfferStatusCodeInOrder, want: true},
+		{name: "reserved_not_yet", settled: StatusIn(model.OfferStatusCodeInOrder, model.OfferStatusCodeSold), status: model.OfferStatusCodeSales, want: false},
+		{name: "released", settled: StatusNotIn(model.OfferStatusCodeInOrder), status: model.OfferStatusCodeSales, want: true},
+		{name: "released_not_yet", settled: StatusNotIn(model.OfferStatusCodeInOrder), status: model.OfferStatusCodeInOrder, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.settled(model.Offer{Status: tt.status}); got != tt.want {
+				t.Errorf("settled() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+type refreshOfferClient struct {
+	offer_service.OfferServiceClient
+}
+
+func (c refreshOfferClient) SearchOffers(_ context.Context, in *offer_service.SearchOffersRequest, _ ...grpc.CallOption) (*offer_service.SearchOffersResponse, error) {
+	response := &offer_service.SearchOffersResponse{}
+	for _, code := range in.OfferCodes {
+		response.Offer = append(response.Offer, &offer_service.Offer{OfferCode: code})
+	}
+	return response, nil
+}
+
+// refreshEnricher computes the statuses of the offers by code, the offers without a status are excluded.
+type refreshEnricher map[string]model.OfferStatusCode
+
+func (e refreshEnricher) Enrich(_ context.Context, offers []*offer_service.Offer) ([]model.Offer, error) {
+	enriched := make([]model.Offer, 0, len(offers))
+	for _, offer := range offers {
+		status := e[offer.OfferCode]
+		enriched = append(enriched, model.Offer{Code: offer.OfferCode, Status: status, Excluded: status == ""})
+	}
+	return enriched, nil
+}
+
+type updatedOfferRepository struct {
+	repository.OfferRepository
+}
+
+func (updatedOfferRepository) Update(context.Context, []model.Offer) error {
+	return nil
+}
+
+func TestRefreshOffers(t *testing.T) {
+	enricher := refreshEnricher{"SOLD": model.OfferStatusCodeSold, "IN_ORDER": model.OfferStatusCodeInOrder}
+	refresh := RefreshOffers(refreshOfferClient{}, enricher, updatedOfferRepository{}, StatusIn(model.OfferStatusCodeSold))
+
+	offers, recheck, err := refresh(context.Background(), []string{"SOLD", "IN_ORDER", "EXCLUDED"})
+	if err != nil {
+		t.Fatalf("refresh() error = %v", err)
+	}
+	if len(offers) != 3 {
+		t.Errorf("refresh() offers = %v, want 3", len(offers))
+	}
+	if !reflect.DeepEqual(recheck, []string{"IN_ORDER"}) {
+		t.Errorf("refresh() recheck = %v, want [IN_ORDER], excluded offers are not rechecked", recheck)
+	}
+}
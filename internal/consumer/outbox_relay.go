@@ -0,0 +1,194 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/samber/lo"
+	"github.com/segmentio/kafka-go"
+	"gitlab.int.tsum.com/core/libraries/corekit.git/kafka/broker"
+	"go.uber.org/zap"
+	"offer-read-service/internal/model"
+	"offer-read-service/internal/repository"
+	"offer-read-service/internal/service"
+)
+
+const (
+	OutboxRelayLeaseName = "outbox_relay"
+
+	// an unconfirmed change is kept while its offer write may still be in progress
+	outboxConfirmTimeout = time.Minute
+)
+
+var outboxPublished = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: "offer_read",
+	Subsystem: "outbox",
+	Name:      "published_total",
+	Help:      "Number of offer status change events published from the outbox.",
+})
+
+var outboxDiscarded = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: "offer_read",
+	Subsystem: "outbox",
+	Name:      "discarded_total",
+	Help:      "Number of offer status change events discarded because the offer write was not confirmed.",
+})
+
+// OutboxRelay publishes the recorded status changes to Kafka and removes them from the outbox once written.
+// A change is published only once the index confirms the offer write, changes whose write failed are discarded.
+// Only the holder of the lease publishes, the events of one offer go to one partition in the order of the changes.
+type OutboxRelay struct {
+	writer           messageWriter
+	outboxRepository repository.OutboxRepository
+	offerRepository  repository.OfferRepository
+	locker           service.Locker
+	batchSize        int
+}
+
+func NewOutboxRelay(config broker.Config, topic string, outboxRepository repository.OutboxRepository, offerRepository repository.OfferRepository, locker service.Locker, batchSize int) *OutboxRelay {
+	return &OutboxRelay{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(config.Brokers...),
+			Topic:        topic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+		},
+		outboxRepository: outboxRepository,
+		offerRepository:  offerRepository,
+		locker:           locker,
+		batchSize:        batchSize,
+	}
+}
+
+func (r *OutboxRelay) Run(ctx context.Context, interval time.Duration) {
+	logger := ctxzap.Extract(ctx)
+	for {
+		lockCtx, unlock, err := r.locker.Lock(ctx)
+		switch {
+		case err == nil:
+			r.relay(lockCtx, interval)
+			unlock()
+		case !errors.Is(err, service.ErrIndexingInProgress):
+			logger.Warn("can't acquire outbox relay lease", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (r *OutboxRelay) relay(ctx context.Context, interval time.Duration) {
+	logger := ctxzap.Extract(ctx)
+	for {
+		published, err := r.publish(ctx)
+		if err != nil && ctx.Err() == nil {
+			logger.Error("can't publish status changes", zap.Error(err))
+		}
+		if published == r.batchSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// publish returns the number of the events removed from the outbox.
+func (r *OutboxRelay) publish(ctx context.Context) (int, error) {
+	events, err := r.outboxRepository.List(ctx, r.batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("outboxRepository.List %w", err)
+	}
+	if len(events) == 0 {
+		return 0, nil
+	}
+	confirmed, discarded, err := r.confirm(ctx, events, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	if len(confirmed) > 0 {
+		messages, err := statusChangedMessages(confirmed)
+		if err != nil {
+			return 0, err
+		}
+		if err = r.writer.WriteMessages(ctx, messages...); err != nil {
+			return 0, fmt.Errorf("writer.WriteMessages %w", err)
+		}
+		outboxPublished.Add(float64(len(confirmed)))
+	}
+	if len(discarded) > 0 {
+		ctxzap.Extract(ctx).Warn("discarding unconfirmed status changes", zap.Int("count", len(discarded)))
+		outboxDiscarded.Add(float64(len(discarded)))
+	}
+
+	removed := append(confirmed, discarded...)
+	if len(removed) == 0 {
+		return 0, nil
+	}
+	err = r.outboxRepository.Delete(ctx, lo.Map(removed, func(item model.OfferStatusChanged, _ int) string {
+		return item.ID
+	}))
+	if err != nil {
+		return 0, fmt.Errorf("outboxRepository.Delete %w", err)
+	}
+	return len(removed), nil
+}
+
+// confirm reads the offers back from the index: a change is confirmed once the offer holds its new status.
+// A change recorded before a failed write is discarded after outboxConfirmTimeout, until then it stays in the outbox.
+func (r *OutboxRelay) confirm(ctx context.Context, events []model.OfferStatusChanged, now time.Time) ([]model.OfferStatusChanged, []model.OfferStatusChanged, error) {
+	offers, err := r.offerRepository.GetOffers(ctx, lo.Uniq(lo.Map(events, func(item model.OfferStatusChanged, _ int) string {
+		return item.OfferCode
+	})))
+	if err != nil {
+		return nil, nil, fmt.Errorf("offerRepository.GetOffers %w", err)
+	}
+	statuses := lo.SliceToMap(offers, func(item model.Offer) (string, model.OfferStatusCode) {
+		return item.Code, item.Status
+	})
+
+	var confirmed, discarded []model.OfferStatusChanged
+	for _, event := range events {
+		switch {
+		case statuses[event.OfferCode] == event.NewStatus:
+			confirmed = append(confirmed, event)
+		case now.Sub(event.ChangedAt) > outboxConfirmTimeout:
+			discarded = append(discarded, event)
+		}
+	}
+	return confirmed, discarded, nil
+}
+
+func (r *OutboxRelay) Close() error {
+	return r.writer.Close()
+}
+
+func statusChangedMessages(events []model.OfferStatusChanged) ([]kafka.Message, error) {
+	messages := make([]kafka.Message, 0, len(events))
+	for _, event := range events {
+		value, err := json.Marshal(event)
+		if err != nil {
+			return nil, fmt.Errorf("json.Marshal %w", err)
+		}
+		messages = append(messages, kafka.Message{
+			Key:   []byte(event.OfferCode),
+			Value: value,
+			Headers: []kafka.Header{
+				{Key: "event_type", Value: []byte("OfferStatusChanged")},
+				{Key: "event_id", Value: []byte(event.ID)},
+			},
+		})
+	}
+	return messages, nil
+}
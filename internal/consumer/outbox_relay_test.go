@@ -0,0 +1,71 @@
+package consumer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/samber/lo"
+
+	"offer-read-service/internal/model"
+	"offer-read-service/internal/repository"
+)
+
+type memoryOutboxRepository struct {
+	repository.OutboxRepository
+	events []model.OfferStatusChanged
+}
+
+func (r *memoryOutboxRepository) List(_ context.Context, size int) ([]model.OfferStatusChanged, error) {
+	return lo.Subset(r.events, 0, uint(size)), nil
+}
+
+func (r *memoryOutboxRepository) Delete(_ context.Context, ids []string) error {
+	r.events = lo.Reject(r.events, func(item model.OfferStatusChanged, _ int) bool {
+		return lo.Contains(ids, item.ID)
+	})
+	return nil
+}
+
+type storedOfferRepository struct {
+	repository.OfferRepository
+	offers []model.Offer
+}
+
+func (r storedOfferRepository) GetOffers(context.Context, []string) ([]model.Offer, error) {
+	return r.offers, nil
+}
+
+func TestOutboxRelay_publish(t *testing.T) {
+	now := time.Now()
+	outbox := &memoryOutboxRepository{events: []model.OfferStatusChanged{
+		{ID: "written", OfferCode: "OFFER-CODE-1", NewStatus: model.OfferStatusCodeSold, ChangedAt: now},
+		{ID: "in_progress", OfferCode: "OFFER-CODE-2", NewStatus: model.OfferStatusCodeSold, ChangedAt: now},
+		{ID: "failed", OfferCode: "OFFER-CODE-3", NewStatus: model.OfferStatusCodeSold, ChangedAt: now.Add(-2 * outboxConfirmTimeout)},
+	}}
+	writer := &memoryMessageWriter{}
+	relay := &OutboxRelay{
+		writer:           writer,
+		outboxRepository: outbox,
+		offerRepository: storedOfferRepository{offers: []model.Offer{
+			{Code: "OFFER-CODE-1", Status: model.OfferStatusCodeSold},
+			{Code: "OFFER-CODE-2", Status: model.OfferStatusCodeInOrder},
+			{Code: "OFFER-CODE-3", Status: model.OfferStatusCodeInOrder},
+		}},
+		batchSize: 10,
+	}
+
+	removed, err := relay.publish(context.Background())
+	if err != nil {
+		t.Fatalf("publish() error = %v", err)
+	}
+	if removed != 2 {
+		t.Errorf("publish() removed = %d, want 2", removed)
+	}
+	if len(writer.messages) != 1 || string(writer.messages[0].Key) != "OFFER-CODE-1" {
+		t.Errorf("published %d messages, want only the confirmed change of OFFER-CODE-1", len(writer.messages))
+	}
+	if len(outbox.events) != 1 || outbox.events[0].ID != "in_progress" {
+		t.Errorf("outbox = %+v, want only the change whose write may be in progress", outbox.events)
+	}
+}
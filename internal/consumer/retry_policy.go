@@ -0,0 +1,101 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/avast/retry-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"gitlab.int.tsum.com/preowned/simona/delta/core.git/custom_error"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	outcomeSuccess          = "success"
+	outcomePermanentFailure = "permanent_failure"
+	outcomeRetriesExhausted = "retries_exhausted"
+	outcomeDeadLetterFailed = "dead_letter_failed"
+)
+
+var (
+	retriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "offer_read",
+		Subsystem: "consumer",
+		Name:      "retries_total",
+		Help:      "Number of event handling retries.",
+	}, []string{"topic"})
+	eventsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "offer_read",
+		Subsystem: "consumer",
+		Name:      "events_total",
+		Help:      "Number of handled events by outcome.",
+	}, []string{"topic", "outcome"})
+)
+
+type RetryPolicy struct {
+	Attempts  uint
+	Delay     time.Duration
+	MaxDelay  time.Duration
+	MaxJitter time.Duration
+	// MaxElapsed bounds all the attempts of one event together, 0 means no bound.
+	MaxElapsed time.Duration
+}
+
+func (p RetryPolicy) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p.MaxElapsed <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, p.MaxElapsed)
+}
+
+func (p RetryPolicy) options(ctx context.Context, onRetry retry.OnRetryFunc) []retry.Option {
+	attempts := p.Attempts
+	if attempts == 0 {
+		attempts = 1
+	}
+	// RandomDelay panics on zero jitter
+	delayType := retry.BackOffDelay
+	if p.MaxJitter > 0 {
+		delayType = retry.CombineDelay(retry.BackOffDelay, retry.RandomDelay)
+	}
+	return []retry.Option{
+		retry.Attempts(attempts),
+		retry.Delay(p.Delay),
+		retry.MaxDelay(p.MaxDelay),
+		retry.MaxJitter(p.MaxJitter),
+		retry.DelayType(delayType),
+		retry.RetryIf(func(err error) bool {
+			return !IsPermanent(err)
+		}),
+		retry.Context(ctx),
+		retry.LastErrorOnly(true),
+		retry.OnRetry(onRetry),
+	}
+}
+
+// IsPermanent reports whether the error will not go away on retry: invalid events and upstream
+// answers which do not depend on the upstream availability. NotFound is retried, right after
+// an event the upstream often hasn't caught up with it yet.
+func IsPermanent(err error) bool {
+	var invalidArgument *custom_error.InvalidArgument
+	var syntaxError *json.SyntaxError
+	var unmarshalTypeError *json.UnmarshalTypeError
+	if errors.As(err, &invalidArgument) || errors.As(err, &syntaxError) || errors.As(err, &unmarshalTypeError) {
+		return true
+	}
+
+	var grpcErr interface{ GRPCStatus() *status.Status }
+	if !errors.As(err, &grpcErr) {
+		return false
+	}
+	switch grpcErr.GRPCStatus().Code() {
+	case codes.InvalidArgument, codes.AlreadyExists, codes.PermissionDenied,
+		codes.FailedPrecondition, codes.OutOfRange, codes.Unimplemented, codes.Unauthenticated:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/avast/retry-go"
+	"gitlab.int.tsum.com/preowned/simona/delta/core.git/custom_error"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestIsPermanent(t *testing.T) {
+	var syntaxError error = &json.SyntaxError{}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "plain", err: errors.New("boom"), want: false},
+		{name: "invalid_argument", err: &custom_error.InvalidArgument{Message: "bad"}, want: true},
+		{name: "wrapped_invalid_argument", err: fmt.Errorf("handle %w", &custom_error.InvalidArgument{Message: "bad"}), want: true},
+		{name: "json", err: fmt.Errorf("json.Unmarshal %w", syntaxError), want: true},
+		{name: "grpc_unavailable", err: status.Error(codes.Unavailable, "unavailable"), want: false},
+		{name: "grpc_deadline", err: fmt.Errorf("offerClient.SearchOffers %w", status.Error(codes.DeadlineExceeded, "deadline")), want: false},
+		{name: "grpc_invalid_argument", err: fmt.Errorf("offerClient.SearchOffers %w", status.Error(codes.InvalidArgument, "bad")), want: true},
+		{name: "grpc_not_found", err: status.Error(codes.NotFound, "not found"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsPermanent(tt.err); got != tt.want {
+				t.Errorf("IsPermanent() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_options(t *testing.T) {
+	tests := []struct {
+		name         string
+		policy       RetryPolicy
+		err          error
+		wantAttempts int
+	}{
+		{name: "retriable", policy: RetryPolicy{Attempts: 3, Delay: time.Millisecond}, err: errors.New("boom"), wantAttempts: 3},
+		{name: "permanent", policy: RetryPolicy{Attempts: 3, Delay: time.Millisecond}, err: &custom_error.InvalidArgument{}, wantAttempts: 1},
+		{name: "zero_attempts", policy: RetryPolicy{Delay: time.Millisecond}, err: errors.New("boom"), wantAttempts: 1},
+		{name: "max_elapsed", policy: RetryPolicy{Attempts: 100, Delay: 20 * time.Millisecond, MaxElapsed: 50 * time.Millisecond}, err: errors.New("boom"), wantAttempts: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := tt.policy.context(context.Background())
+			defer cancel()
+			attempts := 0
+			err := retry.Do(func() error {
+				attempts++
+				return tt.err
+			}, tt.policy.options(ctx, func(uint, error) {})...)
+			if err == nil {
+				t.Fatalf("retry.Do() succeeded")
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("attempts = %v, want %v", attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
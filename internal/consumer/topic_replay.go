@@ -0,0 +1,271 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/samber/lo"
+	"github.com/segmentio/kafka-go"
+	"gitlab.int.tsum.com/core/libraries/corekit.git/kafka/broker"
+	"gitlab.int.tsum.com/preowned/simona/delta/core.git/custom_error"
+	"gitlab.int.tsum.com/preowned/simona/delta/core.git/retrying_consumer"
+	"go.uber.org/zap"
+)
+
+var ErrTopicReplayInProgress = errors.New("topic replay is already running")
+
+// ReplayTopic decodes raw topic messages the way the consumer does and passes them to the handler chain,
+// the offers are refreshed even if they already applied the events.
+func ReplayTopic[T any](handler retrying_consumer.Handler[T]) ReplayFunc {
+	return func(ctx context.Context, value []byte) error {
+		event, err := decodeEvent[T](value)
+		if err != nil {
+			return err
+		}
+		return handler(reapply(ctx), event, retrying_consumer.Meta{})
+	}
+}
+
+// decodeEvent decodes a message value into T with the codec of retrying_consumer, which reads the events as JSON.
+// Dead-lettered events are stored with the same codec.
+func decodeEvent[T any](value []byte) (T, error) {
+	var event T
+	if err := json.Unmarshal(value, &event); err != nil {
+		return event, fmt.Errorf("json.Unmarshal %w", err)
+	}
+	return event, nil
+}
+
+// TopicReplayRequest sets where the replay starts (an offset or a timestamp) and optionally where it ends,
+// without an end the replay stops at the end of the partitions as of its start.
+type TopicReplayRequest struct {
+	Topic      string     `json:"topic"`
+	Partitions []int      `json:"partitions,omitempty"`
+	FromOffset *int64     `json:"from_offset,omitempty"`
+	ToOffset   *int64     `json:"to_offset,omitempty"`
+	From       *time.Time `json:"from,omitempty"`
+	To         *time.Time `json:"to,omitempty"`
+}
+
+func (r TopicReplayRequest) validate() error {
+	if r.Topic == "" {
+		return &custom_error.InvalidArgument{Message: "topic is required"}
+	}
+	if (r.FromOffset == nil) == (r.From == nil) {
+		return &custom_error.InvalidArgument{Message: "exactly one of from_offset and from is required"}
+	}
+	if r.ToOffset != nil && r.To != nil {
+		return &custom_error.InvalidArgument{Message: "only one of to_offset and to is allowed"}
+	}
+	return nil
+}
+
+type PartitionReplayProgress struct {
+	Partition    int   `json:"partition"`
+	Offset       int64 `json:"offset"`
+	EndOffset    int64 `json:"end_offset"`
+	NumProcessed int   `json:"num_processed"`
+	NumFailed    int   `json:"num_failed"`
+	Done         bool  `json:"done"`
+}
+
+type TopicReplayProgress struct {
+	Request    TopicReplayRequest        `json:"request"`
+	StartedAt  time.Time                 `json:"started_at"`
+	FinishedAt *time.Time                `json:"finished_at,omitempty"`
+	Partitions []PartitionReplayProgress `json:"partitions"`
+	Error      string                    `json:"error,omitempty"`
+}
+
+// TopicReplay reprocesses a range of a topic with a temporary consumer outside the consumer group,
+// so the committed offsets of the group stay untouched. Only one replay runs at a time.
+type TopicReplay struct {
+	client   *kafka.Client
+	brokers  []string
+	handlers map[string]ReplayFunc
+
+	mu       sync.Mutex
+	running  bool
+	progress *TopicReplayProgress
+}
+
+func NewTopicReplay(config broker.Config, handlers map[string]ReplayFunc) *TopicReplay {
+	return &TopicReplay{
+		client:   &kafka.Client{Addr: kafka.TCP(config.Brokers...), Timeout: 10 * time.Second},
+		brokers:  config.Brokers,
+		handlers: handlers,
+	}
+}
+
+// Start resolves the partitions and their end offsets and runs the replay in the background.
+func (r *TopicReplay) Start(ctx context.Context, request TopicReplayRequest) (TopicReplayProgress, error) {
+	if err := request.validate(); err != nil {
+		return TopicReplayProgress{}, err
+	}
+	handler, ok := r.handlers[request.Topic]
+	if !ok {
+		return TopicReplayProgress{}, &custom_error.InvalidArgument{Message: fmt.Sprintf("no consumer for topic %q", request.Topic)}
+	}
+
+	r.mu.Lock()
+	if r.running {
+		r.mu.Unlock()
+		return TopicReplayProgress{}, ErrTopicReplayInProgress
+	}
+	r.running = true
+	r.mu.Unlock()
+
+	partitions, err := r.partitions(ctx, request)
+	if err != nil {
+		r.mu.Lock()
+		r.running = false
+		r.mu.Unlock()
+		return TopicReplayProgress{}, err
+	}
+
+	r.mu.Lock()
+	r.progress = &TopicReplayProgress{Request: request, StartedAt: time.Now(), Partitions: partitions}
+	r.mu.Unlock()
+
+	go r.run(ctx, request, handler)
+	return r.Progress(), nil
+}
+
+// Progress returns the state of the running or the last finished replay.
+func (r *TopicReplay) Progress() TopicReplayProgress {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.progress == nil {
+		return TopicReplayProgress{}
+	}
+	progress := *r.progress
+	progress.Partitions = append([]PartitionReplayProgress(nil), r.progress.Partitions...)
+	return progress
+}
+
+func (r *TopicReplay) partitions(ctx context.Context, request TopicReplayRequest) ([]PartitionReplayProgress, error) {
+	metadata, err := r.client.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{request.Topic}})
+	if err != nil {
+		return nil, fmt.Errorf("client.Metadata %w", err)
+	}
+	if len(metadata.Topics) == 0 || metadata.Topics[0].Error != nil {
+		return nil, &custom_error.InvalidArgument{Message: fmt.Sprintf("topic %q not found", request.Topic)}
+	}
+	ids := lo.Map(metadata.Topics[0].Partitions, func(item kafka.Partition, _ int) int {
+		return item.ID
+	})
+	if len(request.Partitions) > 0 {
+		ids = lo.Intersect(ids, request.Partitions)
+	}
+
+	offsets, err := r.client.ListOffsets(ctx, &kafka.ListOffsetsRequest{Topics: map[string][]kafka.OffsetRequest{
+		request.Topic: lo.Map(ids, func(item int, _ int) kafka.OffsetRequest {
+			return kafka.LastOffsetOf(item)
+		}),
+	}})
+	if err != nil {
+		return nil, fmt.Errorf("client.ListOffsets %w", err)
+	}
+
+	partitions := make([]PartitionReplayProgress, 0, len(ids))
+	for _, partition := range offsets.Topics[request.Topic] {
+		if partition.Error != nil {
+			return nil, fmt.Errorf("partition %d offsets %w", partition.Partition, partition.Error)
+		}
+		endOffset := partition.LastOffset
+		if request.ToOffset != nil && *request.ToOffset < endOffset {
+			endOffset = *request.ToOffset
+		}
+		partitions = append(partitions, PartitionReplayProgress{Partition: partition.Partition, EndOffset: endOffset})
+	}
+	return partitions, nil
+}
+
+func (r *TopicReplay) run(ctx context.Context, request TopicReplayRequest, handler ReplayFunc) {
+	logger := ctxzap.Extract(ctx)
+	var wg sync.WaitGroup
+	errs := make([]error, len(r.progress.Partitions))
+	for i, partition := range r.progress.Partitions {
+		wg.Add(1)
+		go func(i, partition int) {
+			defer wg.Done()
+			errs[i] = r.replayPartition(ctx, request, i, partition, handler)
+			if errs[i] != nil {
+				logger.Error("topic partition replay failed", zap.String("topic", request.Topic), zap.Int("partition", partition), zap.Error(errs[i]))
+			}
+		}(i, partition.Partition)
+	}
+	wg.Wait()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	finished := time.Now()
+	r.progress.FinishedAt = &finished
+	if err := errors.Join(errs...); err != nil {
+		r.progress.Error = err.Error()
+	}
+	r.running = false
+	logger.Info("topic replay finished", zap.String("topic", request.Topic), zap.Any("partitions", r.progress.Partitions))
+}
+
+func (r *TopicReplay) replayPartition(ctx context.Context, request TopicReplayRequest, i, partition int, handler ReplayFunc) error {
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   r.brokers,
+		Topic:     request.Topic,
+		Partition: partition,
+	})
+	defer reader.Close()
+
+	var err error
+	if request.FromOffset != nil {
+		err = reader.SetOffset(*request.FromOffset)
+	} else {
+		err = reader.SetOffsetAt(ctx, *request.From)
+	}
+	if err != nil {
+		return fmt.Errorf("reader.SetOffset %w", err)
+	}
+
+	defer r.update(i, func(progress *PartitionReplayProgress) {
+		progress.Done = true
+	})
+	for {
+		r.mu.Lock()
+		endOffset := r.progress.Partitions[i].EndOffset
+		r.mu.Unlock()
+		if reader.Offset() >= endOffset {
+			return nil
+		}
+
+		message, err := reader.FetchMessage(ctx)
+		if err != nil {
+			return fmt.Errorf("reader.FetchMessage %w", err)
+		}
+		if message.Offset >= endOffset || (request.To != nil && message.Time.After(*request.To)) {
+			return nil
+		}
+
+		err = handler(ctx, message.Value)
+		r.update(i, func(progress *PartitionReplayProgress) {
+			progress.Offset = message.Offset + 1
+			progress.NumProcessed++
+			if err != nil {
+				progress.NumFailed++
+			}
+		})
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+func (r *TopicReplay) update(i int, fn func(progress *PartitionReplayProgress)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fn(&r.progress.Partitions[i])
+}
@@ -0,0 +1,72 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"gitlab.int.tsum.com/core/libraries/corekit.git/kafka/broker"
+	"gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/stock"
+	"gitlab.int.tsum.com/preowned/simona/delta/core.git/custom_error"
+	"gitlab.int.tsum.com/preowned/simona/delta/core.git/retrying_consumer"
+)
+
+func TestTopicReplayRequest_validate(t *testing.T) {
+	offset := int64(10)
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		request TopicReplayRequest
+		wantErr bool
+	}{
+		{name: "from offset", request: TopicReplayRequest{Topic: "topic", FromOffset: &offset}},
+		{name: "from time to offset", request: TopicReplayRequest{Topic: "topic", From: &from, ToOffset: &offset}},
+		{name: "no topic", request: TopicReplayRequest{FromOffset: &offset}, wantErr: true},
+		{name: "no start", request: TopicReplayRequest{Topic: "topic"}, wantErr: true},
+		{name: "both starts", request: TopicReplayRequest{Topic: "topic", FromOffset: &offset, From: &from}, wantErr: true},
+		{name: "both ends", request: TopicReplayRequest{Topic: "topic", FromOffset: &offset, ToOffset: &offset, To: &from}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.request.validate()
+			var invalidArgument *custom_error.InvalidArgument
+			if tt.wantErr != errors.As(err, &invalidArgument) {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTopicReplay_Start(t *testing.T) {
+	offset := int64(0)
+	topicReplay := NewTopicReplay(broker.Config{Brokers: []string{"localhost:9092"}}, map[string]ReplayFunc{})
+	_, err := topicReplay.Start(context.Background(), TopicReplayRequest{Topic: "unknown", FromOffset: &offset})
+	var invalidArgument *custom_error.InvalidArgument
+	if !errors.As(err, &invalidArgument) {
+		t.Errorf("Start() of unknown topic error = %v, want invalid argument", err)
+	}
+}
+
+func TestReplayTopic(t *testing.T) {
+	value, err := json.Marshal(&stock.StockUnitSoldEvent{OfferCode: "OFFER-CODE-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got string
+	replay := ReplayTopic(func(_ context.Context, event stock.StockUnitSoldEvent, _ retrying_consumer.Meta) error {
+		got = event.OfferCode
+		return nil
+	})
+	if err = replay(context.Background(), value); err != nil {
+		t.Fatalf("ReplayTopic() error = %v", err)
+	}
+	if got != "OFFER-CODE-1" {
+		t.Errorf("ReplayTopic() offer code = %v, want OFFER-CODE-1", got)
+	}
+	if err = replay(context.Background(), []byte("not json")); !IsPermanent(err) {
+		t.Errorf("ReplayTopic() of malformed value error = %v, want permanent", err)
+	}
+}
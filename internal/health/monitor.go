@@ -0,0 +1,232 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+var (
+	dependencyUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "offer_read",
+		Subsystem: "health",
+		Name:      "dependency_up",
+		Help:      "Whether the dependency passes its health check (1) or not (0).",
+	}, []string{"dependency"})
+	paused = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "offer_read",
+		Subsystem: "health",
+		Name:      "paused",
+		Help:      "Whether event consumption and indexing are paused because of unhealthy dependencies.",
+	})
+)
+
+// Check returns an error while the dependency is unhealthy.
+type Check func(ctx context.Context) error
+
+// GRPCConnection fails while the connection to the upstream can not be established.
+func GRPCConnection(conn *grpc.ClientConn) Check {
+	return func(context.Context) error {
+		if state := conn.GetState(); state == connectivity.TransientFailure || state == connectivity.Shutdown {
+			return fmt.Errorf("connection to %s is %s", conn.Target(), state)
+		}
+		return nil
+	}
+}
+
+type DependencyState struct {
+	Name                string    `json:"name"`
+	Healthy             bool      `json:"healthy"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	LastError           string    `json:"last_error,omitempty"`
+	CheckedAt           time.Time `json:"checked_at"`
+}
+
+type dependency struct {
+	check Check
+	state DependencyState
+}
+
+// Monitor periodically checks the dependencies. A dependency becomes unhealthy after failureThreshold
+// consecutive failed checks and healthy again after the first passed one. The work which can not succeed
+// without the dependencies waits in Wait until all of them are healthy.
+type Monitor struct {
+	failureThreshold int
+
+	mu           sync.Mutex
+	dependencies map[string]*dependency
+	// recovered is closed while all the dependencies are healthy
+	recovered chan struct{}
+}
+
+func NewMonitor(failureThreshold int) *Monitor {
+	recovered := make(chan struct{})
+	close(recovered)
+	return &Monitor{
+		failureThreshold: failureThreshold,
+		dependencies:     map[string]*dependency{},
+		recovered:        recovered,
+	}
+}
+
+func (m *Monitor) Register(name string, check Check) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dependencies[name] = &dependency{check: check, state: DependencyState{Name: name, Healthy: true}}
+	dependencyUp.WithLabelValues(name).Set(1)
+}
+
+func (m *Monitor) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		m.checkAll(ctx, interval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *Monitor) checkAll(ctx context.Context, timeout time.Duration) {
+	logger := ctxzap.Extract(ctx)
+	m.mu.Lock()
+	checks := make(map[string]Check, len(m.dependencies))
+	for name, dependency := range m.dependencies {
+		checks[name] = dependency.check
+	}
+	m.mu.Unlock()
+
+	results := make(map[string]error, len(checks))
+	for name, check := range checks {
+		checkCtx, cancel := context.WithTimeout(ctx, timeout)
+		results[name] = check(checkCtx)
+		cancel()
+	}
+	if ctx.Err() != nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for name, err := range results {
+		state := &m.dependencies[name].state
+		state.CheckedAt = now
+		wasHealthy := state.Healthy
+		if err == nil {
+			state.ConsecutiveFailures, state.LastError, state.Healthy = 0, "", true
+		} else {
+			state.ConsecutiveFailures++
+			state.LastError = err.Error()
+			if state.ConsecutiveFailures >= m.failureThreshold {
+				state.Healthy = false
+			}
+		}
+		switch {
+		case wasHealthy && !state.Healthy:
+			logger.Error("dependency is unhealthy", zap.String("dependency", name), zap.Error(err))
+			dependencyUp.WithLabelValues(name).Set(0)
+		case !wasHealthy && state.Healthy:
+			logger.Info("dependency recovered", zap.String("dependency", name))
+			dependencyUp.WithLabelValues(name).Set(1)
+		}
+	}
+	m.updatePaused()
+}
+
+func (m *Monitor) updatePaused() {
+	healthy := m.unhealthy() == nil
+	select {
+	case <-m.recovered:
+		if !healthy {
+			m.recovered = make(chan struct{})
+			paused.Set(1)
+		}
+	default:
+		if healthy {
+			close(m.recovered)
+			paused.Set(0)
+		}
+	}
+}
+
+func (m *Monitor) unhealthy() error {
+	var errs []error
+	for name, dependency := range m.dependencies {
+		if !dependency.state.Healthy {
+			errs = append(errs, fmt.Errorf("%s: %s", name, dependency.state.LastError))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Healthy returns an error while any of the dependencies is unhealthy, a nil monitor is always healthy.
+func (m *Monitor) Healthy() error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.unhealthy()
+}
+
+// HealthyOf returns an error while the named dependency is unhealthy.
+func (m *Monitor) HealthyOf(name string) error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if dependency, ok := m.dependencies[name]; ok && !dependency.state.Healthy {
+		return fmt.Errorf("%s: %s", name, dependency.state.LastError)
+	}
+	return nil
+}
+
+// Wait blocks until all the dependencies are healthy, a nil monitor never blocks.
+func (m *Monitor) Wait(ctx context.Context) error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	recovered := m.recovered
+	m.mu.Unlock()
+	select {
+	case <-recovered:
+		return nil
+	default:
+	}
+
+	ctxzap.Extract(ctx).Warn("paused until dependencies recover")
+	select {
+	case <-recovered:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m *Monitor) States() []DependencyState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	states := make([]DependencyState, 0, len(m.dependencies))
+	for _, dependency := range m.dependencies {
+		states = append(states, dependency.state)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Name < states[j].Name
+	})
+	return states
+}
@@ -0,0 +1,88 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMonitor_checkAll(t *testing.T) {
+	tests := []struct {
+		name        string
+		results     []error
+		wantHealthy bool
+	}{
+		{name: "passing", results: []error{nil, nil}, wantHealthy: true},
+		{name: "failing below threshold", results: []error{errors.New("down")}, wantHealthy: true},
+		{name: "failing up to threshold", results: []error{errors.New("down"), errors.New("down")}, wantHealthy: false},
+		{name: "recovered", results: []error{errors.New("down"), errors.New("down"), nil}, wantHealthy: true},
+		{name: "failures not in a row", results: []error{errors.New("down"), nil, errors.New("down")}, wantHealthy: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			monitor := NewMonitor(2)
+			var err error
+			monitor.Register("dependency", func(context.Context) error {
+				return err
+			})
+			for _, err = range tt.results {
+				monitor.checkAll(context.Background(), time.Second)
+			}
+			if healthy := monitor.Healthy() == nil; healthy != tt.wantHealthy {
+				t.Errorf("Healthy() = %v, want %v", healthy, tt.wantHealthy)
+			}
+		})
+	}
+}
+
+func TestMonitor_Wait(t *testing.T) {
+	monitor := NewMonitor(1)
+	var err error
+	monitor.Register("dependency", func(context.Context) error {
+		return err
+	})
+	if err := monitor.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait() of healthy monitor error = %v", err)
+	}
+
+	err = errors.New("down")
+	monitor.checkAll(context.Background(), time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := monitor.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait() of unhealthy monitor error = %v, want deadline exceeded", err)
+	}
+
+	waited := make(chan error)
+	go func() {
+		waited <- monitor.Wait(context.Background())
+	}()
+	err = nil
+	monitor.checkAll(context.Background(), time.Second)
+	select {
+	case err := <-waited:
+		if err != nil {
+			t.Errorf("Wait() after recovery error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Wait() did not return after recovery")
+	}
+}
+
+func TestMonitor_HealthyOf(t *testing.T) {
+	monitor := NewMonitor(1)
+	monitor.Register("elasticsearch", func(context.Context) error { return nil })
+	monitor.Register("offer_service", func(context.Context) error { return errors.New("down") })
+	monitor.checkAll(context.Background(), time.Second)
+
+	if err := monitor.HealthyOf("elasticsearch"); err != nil {
+		t.Errorf("HealthyOf(elasticsearch) error = %v, want nil", err)
+	}
+	if err := monitor.HealthyOf("offer_service"); err == nil {
+		t.Errorf("HealthyOf(offer_service) error = nil, want the failure")
+	}
+	if monitor.Healthy() == nil {
+		t.Errorf("Healthy() error = nil, want the failure of offer_service")
+	}
+}
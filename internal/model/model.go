@@ -12,6 +12,21 @@ const (
 	OfferStatusCodeReturnedToSeller OfferStatusCode = `returned-to-seller`
 )
 
+// DataQuality marks offers indexed with incomplete upstream data, empty for complete data.
+// The empty value is written too, the partial update of the offer would keep the previous mark otherwise.
+type DataQuality string
+
+const (
+	// DataQualityCatalogRead means catalog write lacks the item and its data comes from catalog read. Catalog read
+	// lacks the item attributes, so with status overrides configured the offer keeps its previous status.
+	DataQualityCatalogRead DataQuality = `catalog_read`
+	// DataQualityCatalogMissing means both catalogs lack the item and the offer keeps its previous status.
+	DataQualityCatalogMissing DataQuality = `catalog_missing`
+	// DataQualityStockTruncated means the stock service returned the versions of the offer up to the limit only,
+	// so the offer keeps its previous status instead of one calculated from a partial history.
+	DataQualityStockTruncated DataQuality = `stock_truncated`
+)
+
 type OfferStatus struct {
 	Code  OfferStatusCode
 	Title string
@@ -21,6 +36,7 @@ type Offer struct {
 	ID                              int             `json:"offer.id"`
 	Code                            string          `json:"offer.code"`
 	SellerID                        int             `json:"offer.seller_id"`
+	ItemCode                        string          `json:"offer.item_code"`
 	Status                          OfferStatusCode `json:"offer.status"`
 	IsNewCalculateDate              time.Time       `json:"offer.is_new_calculate_date"`
 	IsSalesCalculateDate            time.Time       `json:"offer.is_sales_calculate_date"`
@@ -28,6 +44,9 @@ type Offer struct {
 	IsSoldCalculateDate             time.Time       `json:"offer.is_sold_calculate_date"`
 	IsReturnedToSellerCalculateDate time.Time       `json:"offer.is_returned_to_seller_calculate_date"`
 	Indexed                         time.Time       `json:"offer.indexed"`
+	DataQuality                     DataQuality     `json:"offer.data_quality"`
+	PriceRule                       string          `json:"offer.price_rule"`
+	Excluded                        bool            `json:"-"` // Excluded offers are removed from the index instead of being updated
 }
 
 func (of Offer) GetStatusDate() time.Time {
@@ -45,3 +64,46 @@ func (of Offer) GetStatusDate() time.Time {
 	}
 	return time.Time{}
 }
+
+// AppliedEvent is the last consumed event reflected by the indexed offer.
+type AppliedEvent struct {
+	OfferCode string
+	ID        string
+	Time      time.Time
+}
+
+type DeadLetter struct {
+	OfferCode string    `json:"offer_code"`
+	Reason    string    `json:"reason"`
+	FailedAt  time.Time `json:"failed_at"`
+	Attempts  int       `json:"attempts"`
+}
+
+// Recheck is an offer refresh planned by the consumer of the topic until the offer reflects the event.
+type Recheck struct {
+	Topic     string    `json:"topic"`
+	OfferCode string    `json:"offer_code"`
+	EventTime time.Time `json:"event_time"`
+	DueAt     time.Time `json:"due_at"`
+	Attempt   int       `json:"attempt"`
+}
+
+type Lease struct {
+	Name       string    `json:"name"`
+	Holder     string    `json:"holder"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// OfferStatusChanged is published when the computed status of an indexed offer changes.
+type OfferStatusChanged struct {
+	ID            string          `json:"id"`
+	OfferCode     string          `json:"offer_code"`
+	SellerID      int             `json:"seller_id"`
+	ItemCode      string          `json:"item_code"`
+	OldStatus     OfferStatusCode `json:"old_status"`
+	NewStatus     OfferStatusCode `json:"new_status"`
+	OldStatusDate time.Time       `json:"old_status_date"`
+	NewStatusDate time.Time       `json:"new_status_date"`
+	ChangedAt     time.Time       `json:"changed_at"`
+}
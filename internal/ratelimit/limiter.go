@@ -0,0 +1,225 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+
+	"offer-read-service/internal/upstream"
+)
+
+const (
+	adjustInterval  = time.Second
+	ewmaWeight      = 0.1
+	decreaseFactor  = 0.5
+	increaseFactor  = 0.1
+	minRateFraction = 0.05
+)
+
+var (
+	currentRate = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "offer_read",
+		Subsystem: "ratelimit",
+		Name:      "current_rate",
+		Help:      "Current allowed request rate per second to the upstream, 0 means unlimited.",
+	}, []string{"upstream"})
+	inFlightRequests = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "offer_read",
+		Subsystem: "ratelimit",
+		Name:      "in_flight_requests",
+		Help:      "Number of throttled requests in flight to the upstream.",
+	}, []string{"upstream"})
+	waitDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "offer_read",
+		Subsystem: "ratelimit",
+		Name:      "wait_duration_seconds",
+		Help:      "Time requests spent waiting for the upstream limiter.",
+	}, []string{"upstream"})
+)
+
+type ctxKey struct{}
+
+// WithLimits marks the context so that upstream calls made with it are throttled.
+func WithLimits(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ctxKey{}, true)
+}
+
+func limited(ctx context.Context) bool {
+	v, _ := ctx.Value(ctxKey{}).(bool)
+	return v
+}
+
+type Config struct {
+	// Rate is the maximum number of requests per second, 0 disables the rate limit.
+	Rate float64 `json:"rate"`
+	// Concurrency is the maximum number of requests in flight, 0 disables the limit.
+	Concurrency int `json:"concurrency"`
+	// LatencyThreshold and ErrorRateThreshold trigger the slowdown when exceeded by the moving averages.
+	LatencyThreshold   time.Duration `json:"latency_threshold"`
+	ErrorRateThreshold float64       `json:"error_rate_threshold"`
+}
+
+type State struct {
+	Upstream    string        `json:"upstream"`
+	Config      Config        `json:"config"`
+	CurrentRate float64       `json:"current_rate"`
+	InFlight    int           `json:"in_flight"`
+	Latency     time.Duration `json:"latency"`
+	ErrorRate   float64       `json:"error_rate"`
+}
+
+// Limiter throttles requests to one upstream and adapts the rate to its health:
+// the rate is halved while latency or error rate is above the thresholds and restored gradually afterwards.
+type Limiter struct {
+	upstream string
+
+	mu         sync.Mutex
+	config     Config
+	limiter    *rate.Limiter
+	inFlight   int
+	released   chan struct{}
+	latency    float64
+	errorRate  float64
+	lastAdjust time.Time
+}
+
+func NewLimiter(upstream string, config Config) *Limiter {
+	l := &Limiter{
+		upstream:   upstream,
+		released:   make(chan struct{}),
+		lastAdjust: time.Now(),
+		limiter:    rate.NewLimiter(rate.Inf, 1),
+	}
+	l.SetConfig(config)
+	return l
+}
+
+func (l *Limiter) SetConfig(config Config) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.config = config
+	l.setRate(config.Rate)
+	// waiters may proceed if the concurrency limit was raised
+	close(l.released)
+	l.released = make(chan struct{})
+}
+
+func (l *Limiter) setRate(r float64) {
+	if r <= 0 {
+		l.limiter.SetLimit(rate.Inf)
+		currentRate.WithLabelValues(l.upstream).Set(0)
+		return
+	}
+	l.limiter.SetLimit(rate.Limit(r))
+	l.limiter.SetBurst(int(math.Max(1, math.Ceil(r))))
+	currentRate.WithLabelValues(l.upstream).Set(r)
+}
+
+func (l *Limiter) State() State {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	state := State{
+		Upstream:  l.upstream,
+		Config:    l.config,
+		InFlight:  l.inFlight,
+		Latency:   time.Duration(l.latency),
+		ErrorRate: l.errorRate,
+	}
+	if l.config.Rate > 0 {
+		state.CurrentRate = float64(l.limiter.Limit())
+	}
+	return state
+}
+
+func (l *Limiter) acquire(ctx context.Context) error {
+	started := time.Now()
+	defer func() {
+		waitDuration.WithLabelValues(l.upstream).Observe(time.Since(started).Seconds())
+	}()
+
+	for {
+		l.mu.Lock()
+		if l.config.Concurrency <= 0 || l.inFlight < l.config.Concurrency {
+			l.inFlight++
+			inFlightRequests.WithLabelValues(l.upstream).Set(float64(l.inFlight))
+			l.mu.Unlock()
+			break
+		}
+		released := l.released
+		l.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-released:
+		}
+	}
+
+	if err := l.limiter.Wait(ctx); err != nil {
+		l.release(0, nil)
+		return err
+	}
+	return nil
+}
+
+func (l *Limiter) release(latency time.Duration, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+	inFlightRequests.WithLabelValues(l.upstream).Set(float64(l.inFlight))
+	close(l.released)
+	l.released = make(chan struct{})
+
+	if latency > 0 {
+		l.observe(latency, err)
+	}
+}
+
+func (l *Limiter) observe(latency time.Duration, err error) {
+	failed := 0.0
+	if upstream.IsFailure(err) {
+		failed = 1
+	}
+	l.latency = (1-ewmaWeight)*l.latency + ewmaWeight*float64(latency)
+	l.errorRate = (1-ewmaWeight)*l.errorRate + ewmaWeight*failed
+
+	if l.config.Rate <= 0 || time.Since(l.lastAdjust) < adjustInterval {
+		return
+	}
+	l.lastAdjust = time.Now()
+
+	current := float64(l.limiter.Limit())
+	unhealthy := (l.config.LatencyThreshold > 0 && time.Duration(l.latency) > l.config.LatencyThreshold) ||
+		(l.config.ErrorRateThreshold > 0 && l.errorRate > l.config.ErrorRateThreshold)
+	if unhealthy {
+		l.setRate(math.Max(current*decreaseFactor, l.config.Rate*minRateFraction))
+		return
+	}
+	if current < l.config.Rate {
+		l.setRate(math.Min(current+l.config.Rate*increaseFactor, l.config.Rate))
+	}
+}
+
+// UnaryClientInterceptor applies the limiter to calls made with a context marked by WithLimits.
+func (l *Limiter) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if !limited(ctx) {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		// the wait is cut by the context of the caller, the error is returned with its gRPC code
+		if err := l.acquire(ctx); err != nil {
+			return status.FromContextError(err).Err()
+		}
+		started := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		l.release(time.Since(started), err)
+		return err
+	}
+}
@@ -0,0 +1,110 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestLimiter_observe(t *testing.T) {
+	tests := []struct {
+		name     string
+		current  float64
+		latency  time.Duration
+		err      error
+		wantRate float64
+	}{
+		{
+			name:     "healthy_at_limit",
+			current:  100,
+			latency:  10 * time.Millisecond,
+			wantRate: 100,
+		},
+		{
+			name:     "slow_upstream",
+			current:  100,
+			latency:  5 * time.Second,
+			wantRate: 50,
+		},
+		{
+			name:     "failing_upstream",
+			current:  100,
+			latency:  10 * time.Millisecond,
+			err:      status.Error(codes.Unavailable, "unavailable"),
+			wantRate: 50,
+		},
+		{
+			name:     "not_found_is_not_failure",
+			current:  100,
+			latency:  10 * time.Millisecond,
+			err:      status.Error(codes.NotFound, "not found"),
+			wantRate: 100,
+		},
+		{
+			name:     "minimal_rate",
+			current:  6,
+			latency:  5 * time.Second,
+			wantRate: 5,
+		},
+		{
+			name:     "recovering",
+			current:  50,
+			latency:  10 * time.Millisecond,
+			wantRate: 60,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLimiter(tt.name, Config{
+				Rate:               100,
+				LatencyThreshold:   time.Second,
+				ErrorRateThreshold: 0.05,
+			})
+			l.setRate(tt.current)
+			l.lastAdjust = time.Time{}
+			l.latency = float64(tt.latency)
+
+			l.observe(tt.latency, tt.err)
+			if got := l.State().CurrentRate; got != tt.wantRate {
+				t.Errorf("observe() rate = %v, want %v", got, tt.wantRate)
+			}
+		})
+	}
+}
+
+func TestLimiter_concurrency(t *testing.T) {
+	l := NewLimiter("concurrency", Config{Concurrency: 1})
+	ctx := WithLimits(context.Background())
+	if err := l.acquire(ctx); err != nil {
+		t.Fatalf("acquire() error = %v", err)
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := l.acquire(timeoutCtx); err == nil {
+		t.Fatalf("acquire() over the limit succeeded")
+	}
+	err := l.UnaryClientInterceptor()(timeoutCtx, "/test", nil, nil, nil, func(context.Context, string, any, any, *grpc.ClientConn, ...grpc.CallOption) error {
+		t.Fatalf("call over the limit reached the upstream")
+		return nil
+	})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("interceptor() over the limit error = %v, want code %v", err, codes.DeadlineExceeded)
+	}
+
+	acquired := make(chan error)
+	go func() {
+		acquired <- l.acquire(ctx)
+	}()
+	l.SetConfig(Config{Concurrency: 2})
+	if err := <-acquired; err != nil {
+		t.Fatalf("acquire() after raising the limit error = %v", err)
+	}
+	if got := l.State().InFlight; got != 2 {
+		t.Errorf("InFlight = %v, want 2", got)
+	}
+}
@@ -0,0 +1,126 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/samber/lo"
+	"offer-read-service/internal/model"
+)
+
+//go:embed dead_letter_index_body.json
+var deadLetterIndexBody string
+
+type elasticDeadLetterRepo struct {
+	client    *elasticsearch.Client
+	indexName string
+}
+
+func NewElasticDeadLetterRepo(client *elasticsearch.Client, indexName string) (DeadLetterRepository, error) {
+	err := createOrUpdateIndex(client, indexName, deadLetterIndexBody)
+	if err != nil {
+		return nil, err
+	}
+	return &elasticDeadLetterRepo{client: client, indexName: indexName}, nil
+}
+
+func (e *elasticDeadLetterRepo) Add(ctx context.Context, deadLetters []model.DeadLetter) error {
+	if len(deadLetters) == 0 {
+		return nil
+	}
+	buffer := bytes.NewBuffer(nil)
+	for _, deadLetter := range deadLetters {
+		deadLetter.Attempts = 1
+		upsert, err := json.Marshal(deadLetter)
+		if err != nil {
+			return err
+		}
+		params, err := json.Marshal(map[string]any{
+			"reason":    deadLetter.Reason,
+			"failed_at": deadLetter.FailedAt,
+		})
+		if err != nil {
+			return err
+		}
+		buffer.WriteString(fmt.Sprintf(`{ "update": {"_id": "%s"} }`, deadLetter.OfferCode))
+		buffer.WriteByte('\n')
+		buffer.WriteString(`{ "script": { "source": "ctx._source.attempts += 1; ctx._source.reason = params.reason; ctx._source.failed_at = params.failed_at", "params": `)
+		buffer.Write(params)
+		buffer.WriteString(` }, "upsert": `)
+		buffer.Write(upsert)
+		buffer.WriteString(` }`)
+		buffer.WriteByte('\n')
+	}
+	response, err := e.client.Bulk(
+		buffer,
+		e.client.Bulk.WithIndex(e.indexName),
+		e.client.Bulk.WithContext(ctx),
+	)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	return translateElasticError(response, nil)
+}
+
+func (e *elasticDeadLetterRepo) List(ctx context.Context, size int) ([]model.DeadLetter, error) {
+	buf, err := json.Marshal(map[string]any{
+		"query": map[string]any{"match_all": map[string]any{}},
+		"sort":  []any{map[string]any{"failed_at": "desc"}},
+		"size":  size,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal: %w", err)
+	}
+	searchResp, err := e.client.Search(
+		e.client.Search.WithIndex(e.indexName),
+		e.client.Search.WithBody(bytes.NewReader(buf)),
+		e.client.Search.WithContext(ctx),
+	)
+	err = translateElasticError(searchResp, err)
+	if err != nil {
+		return nil, fmt.Errorf("dead letter List Search error: %w", err)
+	}
+	defer searchResp.Body.Close()
+
+	resp := struct {
+		Hits struct {
+			Hits []struct {
+				Source model.DeadLetter `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}{}
+	err = json.NewDecoder(searchResp.Body).Decode(&resp)
+	if err != nil {
+		return nil, fmt.Errorf("json.Decode %w", err)
+	}
+	return lo.Map(resp.Hits.Hits, func(item struct {
+		Source model.DeadLetter `json:"_source"`
+	}, _ int) model.DeadLetter {
+		return item.Source
+	}), nil
+}
+
+func (e *elasticDeadLetterRepo) Delete(ctx context.Context, offerCodes []string) error {
+	if len(offerCodes) == 0 {
+		return nil
+	}
+	buffer := bytes.NewBuffer(nil)
+	for _, offerCode := range offerCodes {
+		buffer.WriteString(fmt.Sprintf(`{ "delete": {"_id": "%s"} }`, offerCode))
+		buffer.WriteByte('\n')
+	}
+	response, err := e.client.Bulk(
+		buffer,
+		e.client.Bulk.WithIndex(e.indexName),
+		e.client.Bulk.WithContext(ctx),
+	)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	return translateElasticError(response, nil)
+}
@@ -0,0 +1,33 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// CheckElasticHealth fails when the cluster can not be reached or the indices are red, i.e. some of their
+// primary shards are not allocated and writes to them fail.
+func CheckElasticHealth(ctx context.Context, client *elasticsearch.Client, indexNames ...string) error {
+	response, err := client.Cluster.Health(
+		client.Cluster.Health.WithIndex(indexNames...),
+		client.Cluster.Health.WithContext(ctx),
+	)
+	err = translateElasticError(response, err)
+	if err != nil {
+		return fmt.Errorf("Cluster.Health error: %w", err)
+	}
+	defer response.Body.Close()
+
+	health := struct {
+		Status string `json:"status"`
+	}{}
+	if err = json.NewDecoder(response.Body).Decode(&health); err != nil {
+		return fmt.Errorf("json.Decode %w", err)
+	}
+	if health.Status == "red" {
+		return fmt.Errorf("elastic cluster health is %s", health.Status)
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"github.com/elastic/go-elasticsearch/v8"
+	"offer-read-service/internal/model"
+	"time"
+)
+
+//go:embed lease_index_body.json
+var leaseIndexBody string
+
+const (
+	// the lease is taken over only when it is expired, even by the same holder, so it is not re-entrant
+	acquireLeaseScript = `if (ctx._source.expires_at >= params.now) {
+  ctx.op = 'none';
+} else {
+  ctx._source.holder = params.holder;
+  ctx._source.token = params.token;
+  ctx._source.acquired_at = params.now;
+  ctx._source.expires_at = params.expires_at;
+}`
+	renewLeaseScript = `if (ctx._source.token == params.token) {
+  ctx._source.expires_at = params.expires_at;
+} else {
+  ctx.op = 'none';
+}`
+	releaseLeaseScript = `if (ctx._source.token == params.token) {
+  ctx.op = 'delete';
+} else {
+  ctx.op = 'none';
+}`
+)
+
+type elasticLeaseRepo struct {
+	client    *elasticsearch.Client
+	indexName string
+}
+
+type leaseDocument struct {
+	Name       string `json:"name"`
+	Holder     string `json:"holder"`
+	Token      string `json:"token"`
+	AcquiredAt int64  `json:"acquired_at"`
+	ExpiresAt  int64  `json:"expires_at"`
+}
+
+func NewElasticLeaseRepo(client *elasticsearch.Client, indexName string) (LeaseRepository, error) {
+	err := createOrUpdateIndex(client, indexName, leaseIndexBody)
+	if err != nil {
+		return nil, err
+	}
+	return &elasticLeaseRepo{client: client, indexName: indexName}, nil
+}
+
+func (e *elasticLeaseRepo) Acquire(ctx context.Context, name, holder, token string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	return e.update(ctx, name, map[string]any{
+		"script": map[string]any{
+			"source": acquireLeaseScript,
+			"params": map[string]any{
+				"holder":     holder,
+				"token":      token,
+				"now":        now.UnixMilli(),
+				"expires_at": now.Add(ttl).UnixMilli(),
+			},
+		},
+		"upsert": leaseDocument{
+			Name:       name,
+			Holder:     holder,
+			Token:      token,
+			AcquiredAt: now.UnixMilli(),
+			ExpiresAt:  now.Add(ttl).UnixMilli(),
+		},
+	})
+}
+
+func (e *elasticLeaseRepo) Renew(ctx context.Context, name, token string, ttl time.Duration) (bool, error) {
+	return e.update(ctx, name, map[string]any{
+		"script": map[string]any{
+			"source": renewLeaseScript,
+			"params": map[string]any{
+				"token":      token,
+				"expires_at": time.Now().Add(ttl).UnixMilli(),
+			},
+		},
+	})
+}
+
+func (e *elasticLeaseRepo) Release(ctx context.Context, name, token string) error {
+	_, err := e.update(ctx, name, map[string]any{
+		"script": map[string]any{
+			"source": releaseLeaseScript,
+			"params": map[string]any{
+				"token": token,
+			},
+		},
+	})
+	return err
+}
+
+// update returns false when the script left the lease document untouched.
+func (e *elasticLeaseRepo) update(ctx context.Context, name string, body map[string]any) (bool, error) {
+	buf, err := json.Marshal(body)
+	if err != nil {
+		return false, fmt.Errorf("json.Marshal: %w", err)
+	}
+	response, err := e.client.Update(
+		e.indexName,
+		name,
+		bytes.NewReader(buf),
+		e.client.Update.WithContext(ctx),
+		e.client.Update.WithRetryOnConflict(3),
+	)
+	if err != nil {
+		return false, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == 404 {
+		return false, nil
+	}
+	if err = translateElasticError(response, nil); err != nil {
+		return false, fmt.Errorf("lease Update error: %w", err)
+	}
+
+	resp := struct {
+		Result string `json:"result"`
+	}{}
+	err = json.NewDecoder(response.Body).Decode(&resp)
+	if err != nil {
+		return false, fmt.Errorf("json.Decode %w", err)
+	}
+	return resp.Result != "noop", nil
+}
+
+func (e *elasticLeaseRepo) Get(ctx context.Context, name string) (*model.Lease, error) {
+	response, err := e.client.Get(e.indexName, name, e.client.Get.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == 404 {
+		return nil, nil
+	}
+	if err = translateElasticError(response, nil); err != nil {
+		return nil, fmt.Errorf("lease Get error: %w", err)
+	}
+
+	resp := struct {
+		Source leaseDocument `json:"_source"`
+	}{}
+	err = json.NewDecoder(response.Body).Decode(&resp)
+	if err != nil {
+		return nil, fmt.Errorf("json.Decode %w", err)
+	}
+	if time.UnixMilli(resp.Source.ExpiresAt).Before(time.Now()) {
+		return nil, nil
+	}
+	return &model.Lease{
+		Name:       resp.Source.Name,
+		Holder:     resp.Source.Holder,
+		AcquiredAt: time.UnixMilli(resp.Source.AcquiredAt),
+		ExpiresAt:  time.UnixMilli(resp.Source.ExpiresAt),
+	}, nil
+}
@@ -16,8 +16,10 @@ import (
 	"gitlab.int.tsum.com/preowned/simona/delta/core.git/custom_error"
 	"go.uber.org/zap"
 	"io"
+	"net/http"
 	"offer-read-service/internal/model"
 	"strings"
+	"time"
 )
 
 //go:embed index_body.json
@@ -43,14 +45,14 @@ type elasticResponse struct {
 }
 
 func NewElasticRepo(client *elasticsearch.Client, indexName string) (OfferRepository, error) {
-	err := createOrUpdateIndex(client, indexName)
+	err := createOrUpdateIndex(client, indexName, indexBody)
 	if err != nil {
 		return nil, err
 	}
 	return &elasticOfferRepo{client: client, indexName: indexName}, nil
 }
 
-func createOrUpdateIndex(client *elasticsearch.Client, indexName string) error {
+func createOrUpdateIndex(client *elasticsearch.Client, indexName string, indexBody string) error {
 	getResponse, err := client.Indices.Get([]string{indexName})
 	defer getResponse.Body.Close()
 	if err != nil {
@@ -87,8 +89,11 @@ func (e *elasticOfferRepo) Update(ctx context.Context, offers []model.Offer) err
 		e.client.Bulk.WithIndex(e.indexName),
 		e.client.Bulk.WithContext(ctx),
 	)
+	if err != nil {
+		return err
+	}
 	defer response.Body.Close()
-	return translateElasticError(response, err)
+	return translateBulkError(response)
 }
 
 func (e *elasticOfferRepo) ListOffer(ctx context.Context, request v1.GetListRequest) (*ListResponse[model.Offer], error) {
@@ -153,6 +158,298 @@ func (e *elasticOfferRepo) ListOffer(ctx context.Context, request v1.GetListRequ
 	}, nil
 }
 
+func (e *elasticOfferRepo) ListOfferCodes(ctx context.Context, filter OfferFilter, searchAfter string, size int) ([]string, error) {
+	body := map[string]any{
+		"query":   filterToQuery(filter),
+		"sort":    []any{map[string]any{"offer.code": "asc"}},
+		"_source": []string{"offer.code"},
+		"size":    size,
+	}
+	if searchAfter != "" {
+		body["search_after"] = []string{searchAfter}
+	}
+	buf, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal: %w", err)
+	}
+	searchResp, err := e.client.Search(
+		e.client.Search.WithIndex(e.indexName),
+		e.client.Search.WithBody(bytes.NewReader(buf)),
+		e.client.Search.WithContext(ctx),
+	)
+	err = translateElasticError(searchResp, err)
+	if err != nil {
+		return nil, fmt.Errorf("ListOfferCodes Search error: %w", err)
+	}
+	defer searchResp.Body.Close()
+
+	resp := elasticResponse{}
+	err = json.NewDecoder(searchResp.Body).Decode(&resp)
+	if err != nil {
+		return nil, fmt.Errorf("json.Decode %w", err)
+	}
+	return lo.Map(resp.Hits.Hits, func(item struct {
+		Source model.Offer `json:"_source,omitempty"`
+	}, _ int) string {
+		return item.Source.Code
+	}), nil
+}
+
+// GetOffers reads the offers by id, _mget is realtime and sees the offers written right before it.
+func (e *elasticOfferRepo) GetOffers(ctx context.Context, codes []string) ([]model.Offer, error) {
+	docs, err := e.mget(ctx, codes)
+	if err != nil {
+		return nil, fmt.Errorf("GetOffers %w", err)
+	}
+	offers := make([]model.Offer, 0, len(docs))
+	for _, doc := range docs {
+		var offer model.Offer
+		if err = json.Unmarshal(doc, &offer); err != nil {
+			return nil, fmt.Errorf("json.Unmarshal %w", err)
+		}
+		offers = append(offers, offer)
+	}
+	return offers, nil
+}
+
+// mget returns the sources of the found documents, the missing ones are skipped.
+func (e *elasticOfferRepo) mget(ctx context.Context, codes []string, sourceIncludes ...string) ([]json.RawMessage, error) {
+	if len(codes) == 0 {
+		return nil, nil
+	}
+	buf, err := json.Marshal(map[string]any{"ids": codes})
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal: %w", err)
+	}
+	options := []func(*esapi.MgetRequest){
+		e.client.Mget.WithIndex(e.indexName),
+		e.client.Mget.WithContext(ctx),
+	}
+	if len(sourceIncludes) > 0 {
+		options = append(options, e.client.Mget.WithSourceIncludes(sourceIncludes...))
+	}
+	mgetResp, err := e.client.Mget(bytes.NewReader(buf), options...)
+	err = translateElasticError(mgetResp, err)
+	if err != nil {
+		return nil, fmt.Errorf("Mget error: %w", err)
+	}
+	defer mgetResp.Body.Close()
+
+	resp := struct {
+		Docs []struct {
+			Found  bool            `json:"found"`
+			Source json.RawMessage `json:"_source"`
+		} `json:"docs"`
+	}{}
+	if err = json.NewDecoder(mgetResp.Body).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("json.Decode %w", err)
+	}
+	docs := make([]json.RawMessage, 0, len(resp.Docs))
+	for _, doc := range resp.Docs {
+		if doc.Found {
+			docs = append(docs, doc.Source)
+		}
+	}
+	return docs, nil
+}
+
+func (e *elasticOfferRepo) SampleOfferCodes(ctx context.Context, size int) ([]string, error) {
+	buf, err := json.Marshal(map[string]any{
+		"query": map[string]any{
+			"function_score": map[string]any{
+				"query":        map[string]any{"match_all": map[string]any{}},
+				"random_score": map[string]any{},
+			},
+		},
+		"_source": []string{"offer.code"},
+		"size":    size,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal: %w", err)
+	}
+	searchResp, err := e.client.Search(
+		e.client.Search.WithIndex(e.indexName),
+		e.client.Search.WithBody(bytes.NewReader(buf)),
+		e.client.Search.WithContext(ctx),
+	)
+	err = translateElasticError(searchResp, err)
+	if err != nil {
+		return nil, fmt.Errorf("SampleOfferCodes Search error: %w", err)
+	}
+	defer searchResp.Body.Close()
+
+	resp := elasticResponse{}
+	err = json.NewDecoder(searchResp.Body).Decode(&resp)
+	if err != nil {
+		return nil, fmt.Errorf("json.Decode %w", err)
+	}
+	return lo.Map(resp.Hits.Hits, func(item struct {
+		Source model.Offer `json:"_source,omitempty"`
+	}, _ int) string {
+		return item.Source.Code
+	}), nil
+}
+
+func (e *elasticOfferRepo) LastOfferID(ctx context.Context) (int64, error) {
+	buf, err := json.Marshal(map[string]any{
+		"sort":    []map[string]any{{"offer.id": map[string]any{"order": "desc"}}},
+		"_source": []string{"offer.id"},
+		"size":    1,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("json.Marshal: %w", err)
+	}
+	searchResp, err := e.client.Search(
+		e.client.Search.WithIndex(e.indexName),
+		e.client.Search.WithBody(bytes.NewReader(buf)),
+		e.client.Search.WithContext(ctx),
+	)
+	err = translateElasticError(searchResp, err)
+	if err != nil {
+		return 0, fmt.Errorf("LastOfferID Search error: %w", err)
+	}
+	defer searchResp.Body.Close()
+
+	resp := elasticResponse{}
+	err = json.NewDecoder(searchResp.Body).Decode(&resp)
+	if err != nil {
+		return 0, fmt.Errorf("json.Decode %w", err)
+	}
+	if len(resp.Hits.Hits) == 0 {
+		return 0, nil
+	}
+	return int64(resp.Hits.Hits[0].Source.ID), nil
+}
+
+func (e *elasticOfferRepo) CountOffers(ctx context.Context, filter OfferFilter) (int64, error) {
+	buf, err := json.Marshal(map[string]any{"query": filterToQuery(filter)})
+	if err != nil {
+		return 0, fmt.Errorf("json.Marshal: %w", err)
+	}
+	countResp, err := e.client.Count(
+		e.client.Count.WithIndex(e.indexName),
+		e.client.Count.WithBody(bytes.NewReader(buf)),
+		e.client.Count.WithContext(ctx),
+	)
+	err = translateElasticError(countResp, err)
+	if err != nil {
+		return 0, fmt.Errorf("CountOffers Count error: %w", err)
+	}
+	defer countResp.Body.Close()
+
+	resp := struct {
+		Count int64 `json:"count"`
+	}{}
+	err = json.NewDecoder(countResp.Body).Decode(&resp)
+	if err != nil {
+		return 0, fmt.Errorf("json.Decode %w", err)
+	}
+	return resp.Count, nil
+}
+
+// LastEvents reads the applied events with _mget, which is realtime and sees the events marked right before it.
+func (e *elasticOfferRepo) LastEvents(ctx context.Context, codes []string) (map[string]model.AppliedEvent, error) {
+	docs, err := e.mget(ctx, codes, "offer.code", "offer.last_event_id", "offer.last_event_time")
+	if err != nil {
+		return nil, fmt.Errorf("LastEvents %w", err)
+	}
+	events := make(map[string]model.AppliedEvent, len(docs))
+	for _, doc := range docs {
+		source := struct {
+			Code          string `json:"offer.code"`
+			LastEventID   string `json:"offer.last_event_id"`
+			LastEventTime int64  `json:"offer.last_event_time"`
+		}{}
+		if err = json.Unmarshal(doc, &source); err != nil {
+			return nil, fmt.Errorf("json.Unmarshal %w", err)
+		}
+		if source.LastEventTime == 0 {
+			continue
+		}
+		events[source.Code] = model.AppliedEvent{
+			OfferCode: source.Code,
+			ID:        source.LastEventID,
+			Time:      time.UnixMilli(source.LastEventTime),
+		}
+	}
+	return events, nil
+}
+
+// the event is stored only if it is not older than the stored one, concurrent consumers can not move it back
+const markEventAppliedScript = `if (ctx._source['offer.last_event_time'] == null || ctx._source['offer.last_event_time'] <= params.time) {
+  ctx._source['offer.last_event_id'] = params.id;
+  ctx._source['offer.last_event_time'] = params.time;
+} else {
+  ctx.op = 'none';
+}`
+
+// MarkEventsApplied leaves offers which are not indexed untouched.
+func (e *elasticOfferRepo) MarkEventsApplied(ctx context.Context, events []model.AppliedEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	source, err := json.Marshal(markEventAppliedScript)
+	if err != nil {
+		return err
+	}
+	buffer := bytes.NewBuffer(nil)
+	for _, event := range events {
+		params, err := json.Marshal(map[string]any{
+			"id":   event.ID,
+			"time": event.Time.UnixMilli(),
+		})
+		if err != nil {
+			return err
+		}
+		buffer.WriteString(fmt.Sprintf(`{ "update": {"_id": "%s", "retry_on_conflict": 3} }`, event.OfferCode))
+		buffer.WriteByte('\n')
+		buffer.WriteString(`{ "script": { "source": `)
+		buffer.Write(source)
+		buffer.WriteString(`, "params": `)
+		buffer.Write(params)
+		buffer.WriteString(` } }`)
+		buffer.WriteByte('\n')
+	}
+	response, err := e.client.Bulk(
+		buffer,
+		e.client.Bulk.WithIndex(e.indexName),
+		e.client.Bulk.WithContext(ctx),
+	)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	// an offer which is not indexed has no document to update
+	return translateBulkError(response, http.StatusNotFound)
+}
+
+func filterToQuery(filter OfferFilter) map[string]any {
+	var filters []any
+	if len(filter.Codes) > 0 {
+		filters = append(filters, map[string]any{"terms": map[string]any{"offer.code": filter.Codes}})
+	}
+	if len(filter.SellerIDs) > 0 {
+		filters = append(filters, map[string]any{"terms": map[string]any{"offer.seller_id": filter.SellerIDs}})
+	}
+	if len(filter.ItemCodes) > 0 {
+		filters = append(filters, map[string]any{"terms": map[string]any{"offer.item_code": filter.ItemCodes}})
+	}
+	if len(filter.Statuses) > 0 {
+		filters = append(filters, map[string]any{"terms": map[string]any{"offer.status": filter.Statuses}})
+	}
+	query := map[string]any{}
+	if len(filters) > 0 {
+		query["filter"] = filters
+	}
+	if filter.WithoutItemCode {
+		query["must_not"] = []any{map[string]any{"exists": map[string]any{"field": "offer.item_code"}}}
+	}
+	if len(query) == 0 {
+		return map[string]any{"match_all": map[string]any{}}
+	}
+	return map[string]any{"bool": query}
+}
+
 func translateElasticError(searchResp *esapi.Response, err error) error {
 	if err != nil {
 		return err
@@ -164,16 +461,66 @@ func translateElasticError(searchResp *esapi.Response, err error) error {
 		if searchResp.StatusCode >= 500 {
 			return fmt.Errorf("elastic response error %s", searchResp.String())
 		}
-		if searchResp.StatusCode >= 400 {
+		if searchResp.StatusCode >= 400 && !isRetryableStatus(searchResp.StatusCode) {
 			return &custom_error.InvalidArgument{Message: fmt.Sprintf("elastic searchResp, error %s", searchResp.String())}
 		}
+		if searchResp.StatusCode >= 400 {
+			return fmt.Errorf("elastic response error %s", searchResp.String())
+		}
 	}
 	return nil
 }
 
+// translateBulkError also fails on the errors of single bulk items, the outbox must not lose events silently
+// and the indexator must not count rejected offers as indexed. A rejected document is an InvalidArgument,
+// rejections under load and version conflicts are worth a retry.
+// The items failing with one of ignoredStatuses are not errors.
+func translateBulkError(response *esapi.Response, ignoredStatuses ...int) error {
+	if err := translateElasticError(response, nil); err != nil {
+		return err
+	}
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return fmt.Errorf("io.ReadAll, error: %w", err)
+	}
+	if !gjson.GetBytes(body, "errors").Bool() {
+		return nil
+	}
+	var reason string
+	var itemStatus int64
+	gjson.GetBytes(body, "items").ForEach(func(_, item gjson.Result) bool {
+		item.ForEach(func(_, action gjson.Result) bool {
+			itemStatus = action.Get("status").Int()
+			if !lo.Contains(ignoredStatuses, int(itemStatus)) {
+				reason = action.Get("error.reason").String()
+			}
+			return reason == ""
+		})
+		return reason == ""
+	})
+	if reason == "" {
+		return nil
+	}
+	if itemStatus >= 400 && itemStatus < 500 && !isRetryableStatus(int(itemStatus)) {
+		return &custom_error.InvalidArgument{Message: fmt.Sprintf("elastic bulk item error %s", reason)}
+	}
+	return fmt.Errorf("elastic bulk item error %s", reason)
+}
+
+// isRetryableStatus reports the client errors of Elasticsearch which are worth a retry:
+// rejections under load and version conflicts.
+func isRetryableStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode == http.StatusConflict
+}
+
 func modelsToReader(offers []model.Offer) (io.Reader, error) {
 	buffer := bytes.NewBuffer(nil)
 	for _, o := range offers {
+		if o.Excluded {
+			buffer.WriteString(fmt.Sprintf(`{ "delete": {"_id": "%s"} }`, o.Code))
+			buffer.WriteByte('\n')
+			continue
+		}
 		byt, err := json.Marshal(o)
 		if err != nil {
 			return nil, err
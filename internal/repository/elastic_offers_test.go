@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+
+	"offer-read-service/internal/model"
+)
+
+func Test_modelsToReader(t *testing.T) {
+	tests := []struct {
+		name       string
+		offer      model.Offer
+		wantFields map[string]string
+	}{
+		{
+			name:  "marked",
+			offer: model.Offer{Code: "OFFER-CODE-1", DataQuality: model.DataQualityCatalogRead, PriceRule: "rub_minimum"},
+			wantFields: map[string]string{
+				"offer.data_quality": string(model.DataQualityCatalogRead),
+				"offer.price_rule":   "rub_minimum",
+			},
+		},
+		{
+			// the partial update keeps the fields missing in the document, the recovered offer must clear the marks
+			name:  "recovered",
+			offer: model.Offer{Code: "OFFER-CODE-1"},
+			wantFields: map[string]string{
+				"offer.data_quality": "",
+				"offer.price_rule":   "",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader, err := modelsToReader([]model.Offer{tt.offer})
+			if err != nil {
+				t.Fatalf("modelsToReader() error = %v", err)
+			}
+			scanner := bufio.NewScanner(reader)
+			var lines []string
+			for scanner.Scan() {
+				lines = append(lines, scanner.Text())
+			}
+			if len(lines) != 2 {
+				t.Fatalf("modelsToReader() lines = %v, want action and document", lines)
+			}
+			for field, want := range tt.wantFields {
+				value := gjson.Get(lines[1], "doc."+strings.ReplaceAll(field, ".", `\.`))
+				if !value.Exists() {
+					t.Errorf("modelsToReader() document %s lacks %s", lines[1], field)
+				} else if value.String() != want {
+					t.Errorf("modelsToReader() %s = %q, want %q", field, value.String(), want)
+				}
+			}
+		})
+	}
+}
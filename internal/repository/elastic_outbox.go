@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/samber/lo"
+	"offer-read-service/internal/model"
+)
+
+//go:embed outbox_index_body.json
+var outboxIndexBody string
+
+type elasticOutboxRepo struct {
+	client    *elasticsearch.Client
+	indexName string
+}
+
+func NewElasticOutboxRepo(client *elasticsearch.Client, indexName string) (OutboxRepository, error) {
+	err := createOrUpdateIndex(client, indexName, outboxIndexBody)
+	if err != nil {
+		return nil, err
+	}
+	return &elasticOutboxRepo{client: client, indexName: indexName}, nil
+}
+
+// Add stores the events by their ids, so an event recorded twice before it is published is kept once.
+func (e *elasticOutboxRepo) Add(ctx context.Context, events []model.OfferStatusChanged) error {
+	if len(events) == 0 {
+		return nil
+	}
+	buffer := bytes.NewBuffer(nil)
+	for _, event := range events {
+		byt, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		buffer.WriteString(fmt.Sprintf(`{ "index": {"_id": "%s"} }`, event.ID))
+		buffer.WriteByte('\n')
+		buffer.Write(byt)
+		buffer.WriteByte('\n')
+	}
+	response, err := e.client.Bulk(
+		buffer,
+		e.client.Bulk.WithIndex(e.indexName),
+		e.client.Bulk.WithContext(ctx),
+	)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	return translateBulkError(response)
+}
+
+func (e *elasticOutboxRepo) List(ctx context.Context, size int) ([]model.OfferStatusChanged, error) {
+	buf, err := json.Marshal(map[string]any{
+		"query": map[string]any{"match_all": map[string]any{}},
+		"sort":  []any{map[string]any{"changed_at": "asc"}},
+		"size":  size,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal: %w", err)
+	}
+	searchResp, err := e.client.Search(
+		e.client.Search.WithIndex(e.indexName),
+		e.client.Search.WithBody(bytes.NewReader(buf)),
+		e.client.Search.WithContext(ctx),
+	)
+	err = translateElasticError(searchResp, err)
+	if err != nil {
+		return nil, fmt.Errorf("outbox List Search error: %w", err)
+	}
+	defer searchResp.Body.Close()
+
+	resp := struct {
+		Hits struct {
+			Hits []struct {
+				Source model.OfferStatusChanged `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}{}
+	err = json.NewDecoder(searchResp.Body).Decode(&resp)
+	if err != nil {
+		return nil, fmt.Errorf("json.Decode %w", err)
+	}
+	return lo.Map(resp.Hits.Hits, func(item struct {
+		Source model.OfferStatusChanged `json:"_source"`
+	}, _ int) model.OfferStatusChanged {
+		return item.Source
+	}), nil
+}
+
+// Delete waits for the refresh, so the next List does not return the published events again.
+func (e *elasticOutboxRepo) Delete(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	buffer := bytes.NewBuffer(nil)
+	for _, id := range ids {
+		buffer.WriteString(fmt.Sprintf(`{ "delete": {"_id": "%s"} }`, id))
+		buffer.WriteByte('\n')
+	}
+	response, err := e.client.Bulk(
+		buffer,
+		e.client.Bulk.WithIndex(e.indexName),
+		e.client.Bulk.WithContext(ctx),
+		e.client.Bulk.WithRefresh("wait_for"),
+	)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	return translateBulkError(response)
+}
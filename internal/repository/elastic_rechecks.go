@@ -0,0 +1,119 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/samber/lo"
+	"offer-read-service/internal/model"
+)
+
+//go:embed recheck_index_body.json
+var recheckIndexBody string
+
+type elasticRecheckRepo struct {
+	client    *elasticsearch.Client
+	indexName string
+}
+
+func NewElasticRecheckRepo(client *elasticsearch.Client, indexName string) (RecheckRepository, error) {
+	err := createOrUpdateIndex(client, indexName, recheckIndexBody)
+	if err != nil {
+		return nil, err
+	}
+	return &elasticRecheckRepo{client: client, indexName: indexName}, nil
+}
+
+// recheckID keeps one recheck of the offer per topic.
+func recheckID(topic, offerCode string) string {
+	return topic + ":" + offerCode
+}
+
+func (e *elasticRecheckRepo) Save(ctx context.Context, rechecks []model.Recheck) error {
+	if len(rechecks) == 0 {
+		return nil
+	}
+	buffer := bytes.NewBuffer(nil)
+	for _, recheck := range rechecks {
+		doc, err := json.Marshal(recheck)
+		if err != nil {
+			return err
+		}
+		buffer.WriteString(fmt.Sprintf(`{ "index": {"_id": "%s"} }`, recheckID(recheck.Topic, recheck.OfferCode)))
+		buffer.WriteByte('\n')
+		buffer.Write(doc)
+		buffer.WriteByte('\n')
+	}
+	response, err := e.client.Bulk(
+		buffer,
+		e.client.Bulk.WithIndex(e.indexName),
+		e.client.Bulk.WithContext(ctx),
+	)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	return translateBulkError(response)
+}
+
+func (e *elasticRecheckRepo) List(ctx context.Context, topic string, size int) ([]model.Recheck, error) {
+	buf, err := json.Marshal(map[string]any{
+		"query": map[string]any{"term": map[string]any{"topic": topic}},
+		"sort":  []any{map[string]any{"due_at": "asc"}},
+		"size":  size,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal: %w", err)
+	}
+	searchResp, err := e.client.Search(
+		e.client.Search.WithIndex(e.indexName),
+		e.client.Search.WithBody(bytes.NewReader(buf)),
+		e.client.Search.WithContext(ctx),
+	)
+	err = translateElasticError(searchResp, err)
+	if err != nil {
+		return nil, fmt.Errorf("recheck List Search error: %w", err)
+	}
+	defer searchResp.Body.Close()
+
+	resp := struct {
+		Hits struct {
+			Hits []struct {
+				Source model.Recheck `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}{}
+	err = json.NewDecoder(searchResp.Body).Decode(&resp)
+	if err != nil {
+		return nil, fmt.Errorf("json.Decode %w", err)
+	}
+	return lo.Map(resp.Hits.Hits, func(item struct {
+		Source model.Recheck `json:"_source"`
+	}, _ int) model.Recheck {
+		return item.Source
+	}), nil
+}
+
+func (e *elasticRecheckRepo) Delete(ctx context.Context, topic string, offerCodes []string) error {
+	if len(offerCodes) == 0 {
+		return nil
+	}
+	buffer := bytes.NewBuffer(nil)
+	for _, offerCode := range offerCodes {
+		buffer.WriteString(fmt.Sprintf(`{ "delete": {"_id": "%s"} }`, recheckID(topic, offerCode)))
+		buffer.WriteByte('\n')
+	}
+	response, err := e.client.Bulk(
+		buffer,
+		e.client.Bulk.WithIndex(e.indexName),
+		e.client.Bulk.WithContext(ctx),
+	)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	return translateElasticError(response, nil)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	v1 "gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/common/search_kit/v1"
 	"offer-read-service/internal/model"
+	"time"
 )
 
 type ListResponse[T any] struct {
@@ -11,9 +12,57 @@ type ListResponse[T any] struct {
 	Data  []T
 }
 
+type OfferFilter struct {
+	Codes     []string
+	SellerIDs []int64
+	ItemCodes []string
+	Statuses  []model.OfferStatusCode
+	// WithoutItemCode selects the offers indexed before the item code was stored
+	WithoutItemCode bool
+}
+
 type OfferRepository interface {
 	Update(context.Context, []model.Offer) error
 	ListOffer(context.Context, v1.GetListRequest) (*ListResponse[model.Offer], error)
+	ListOfferCodes(ctx context.Context, filter OfferFilter, searchAfter string, size int) ([]string, error)
+	GetOffers(ctx context.Context, codes []string) ([]model.Offer, error)
+	SampleOfferCodes(ctx context.Context, size int) ([]string, error)
+	CountOffers(context.Context, OfferFilter) (int64, error)
+	// LastOfferID returns the id of the newest offer in the index, zero for an empty index.
+	LastOfferID(context.Context) (int64, error)
+	// LastEvents returns the last applied events of the offers, offers without events are omitted.
+	LastEvents(ctx context.Context, codes []string) (map[string]model.AppliedEvent, error)
+	// MarkEventsApplied stores the events alongside the offers unless they already reflect a later event.
+	MarkEventsApplied(ctx context.Context, events []model.AppliedEvent) error
+}
+
+type DeadLetterRepository interface {
+	Add(context.Context, []model.DeadLetter) error
+	List(ctx context.Context, size int) ([]model.DeadLetter, error)
+	Delete(ctx context.Context, offerCodes []string) error
+}
+
+// OutboxRepository keeps the status change events until they are published.
+type OutboxRepository interface {
+	Add(context.Context, []model.OfferStatusChanged) error
+	List(ctx context.Context, size int) ([]model.OfferStatusChanged, error)
+	Delete(ctx context.Context, ids []string) error
+}
+
+// RecheckRepository keeps the planned rechecks, so they survive a restart of the consumer.
+type RecheckRepository interface {
+	Save(context.Context, []model.Recheck) error
+	List(ctx context.Context, topic string, size int) ([]model.Recheck, error)
+	Delete(ctx context.Context, topic string, offerCodes []string) error
+}
+
+// LeaseRepository keeps the leases. Every acquisition is identified by its own token, so the same holder
+// can't take the lease twice and renews or releases only the lease it acquired.
+type LeaseRepository interface {
+	Acquire(ctx context.Context, name, holder, token string, ttl time.Duration) (bool, error)
+	Renew(ctx context.Context, name, token string, ttl time.Duration) (bool, error)
+	Release(ctx context.Context, name, token string) error
+	Get(ctx context.Context, name string) (*model.Lease, error)
 }
 
 type OfferStatusRepository interface {
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/samber/lo"
+	"gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/offer_service"
+	"go.uber.org/zap"
+	"offer-read-service/internal/model"
+	"offer-read-service/internal/ratelimit"
+	"offer-read-service/internal/repository"
+)
+
+var (
+	auditedOffers = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "offer_read",
+		Subsystem: "auditor",
+		Name:      "audited_offers_total",
+		Help:      "Number of audited offers by indexed status.",
+	}, []string{"status"})
+	mismatchedOffers = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "offer_read",
+		Subsystem: "auditor",
+		Name:      "mismatched_offers_total",
+		Help:      "Number of audited offers whose indexed status differs from the computed one, by indexed status.",
+	}, []string{"status"})
+	mismatchRate = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "offer_read",
+		Subsystem: "auditor",
+		Name:      "mismatch_rate",
+		Help:      "Share of mismatched offers in the last audited sample, by indexed status.",
+	}, []string{"status"})
+)
+
+// auditStatusMissing labels the sampled offers which are gone from the index by the time they are read.
+const auditStatusMissing model.OfferStatusCode = "missing"
+
+type AuditResult struct {
+	NumAudited    int
+	NumMismatched int
+	NumFixed      int
+}
+
+type Auditor interface {
+	Audit(ctx context.Context) (AuditResult, error)
+}
+
+type auditor struct {
+	offerClient     offer_service.OfferServiceClient
+	offerEnricher   OfferEnricher
+	offerRepository repository.OfferRepository
+	sampleSize      int
+	fix             bool
+}
+
+func NewAuditor(offerClient offer_service.OfferServiceClient, repo repository.OfferRepository, offerEnricher OfferEnricher, sampleSize int, fix bool) Auditor {
+	return &auditor{
+		offerClient:     offerClient,
+		offerEnricher:   offerEnricher,
+		offerRepository: repo,
+		sampleSize:      sampleSize,
+		fix:             fix,
+	}
+}
+
+func (s *auditor) Audit(ctx context.Context) (AuditResult, error) {
+	ctx = ratelimit.WithLimits(ctx)
+	logger := ctxzap.Extract(ctx)
+	offerCodes, err := s.offerRepository.SampleOfferCodes(ctx, s.sampleSize)
+	if err != nil {
+		return AuditResult{}, fmt.Errorf("can't SampleOfferCodes %w", err)
+	}
+	if len(offerCodes) == 0 {
+		return AuditResult{}, nil
+	}
+
+	storedOffers, err := s.offerRepository.GetOffers(ctx, offerCodes)
+	if err != nil {
+		return AuditResult{}, fmt.Errorf("can't get offers from elastic %w", err)
+	}
+	offers, err := searchOffersByCodes(ctx, s.offerClient, offerCodes)
+	if err != nil {
+		return AuditResult{}, err
+	}
+	richOffers, err := s.offerEnricher.Enrich(ctx, offers)
+	if err != nil {
+		return AuditResult{}, fmt.Errorf("can't enrich %w", err)
+	}
+	computed := lo.SliceToMap(richOffers, func(item model.Offer) (string, model.Offer) {
+		return item.Code, item
+	})
+
+	stored := lo.SliceToMap(storedOffers, func(item model.Offer) (string, model.Offer) {
+		return item.Code, item
+	})
+
+	result := AuditResult{}
+	audited := map[model.OfferStatusCode]int{}
+	mismatched := map[model.OfferStatusCode]int{}
+	var fixes []model.Offer
+	for _, offerCode := range lo.Uniq(offerCodes) {
+		storedOffer, indexed := stored[offerCode]
+		offer, found := computed[offerCode]
+		status := storedOffer.Status
+		if !indexed {
+			status = auditStatusMissing
+		}
+		result.NumAudited++
+		audited[status]++
+		if auditMatches(storedOffer, indexed, offer, found) {
+			continue
+		}
+		result.NumMismatched++
+		mismatched[status]++
+		if found {
+			fixes = append(fixes, offer)
+		}
+		logger.Info("offer status mismatch",
+			zap.String("offer_code", offerCode),
+			zap.String("indexed_status", string(status)),
+			zap.String("computed_status", computedAuditStatus(offer, found)),
+		)
+	}
+
+	// the statuses absent from this sample must not keep the rate of the previous one
+	mismatchRate.Reset()
+	for status, count := range audited {
+		auditedOffers.WithLabelValues(string(status)).Add(float64(count))
+		mismatchedOffers.WithLabelValues(string(status)).Add(float64(mismatched[status]))
+		mismatchRate.WithLabelValues(string(status)).Set(float64(mismatched[status]) / float64(count))
+	}
+
+	if s.fix && len(fixes) > 0 {
+		err = s.offerRepository.Update(ctx, fixes)
+		if err != nil {
+			return result, fmt.Errorf("can't update in elastic %w", err)
+		}
+		result.NumFixed = len(fixes)
+	}
+
+	return result, nil
+}
+
+// auditMatches reports whether the index agrees with the computed offer. An offer missing upstream never matches,
+// an offer excluded by the status overrides is expected to be absent from the index.
+func auditMatches(stored model.Offer, indexed bool, computed model.Offer, found bool) bool {
+	switch {
+	case !found:
+		return false
+	case computed.Excluded:
+		return !indexed
+	}
+	return indexed && computed.Status == stored.Status
+}
+
+// computedAuditStatus describes the computed state of the offer in the mismatch log.
+func computedAuditStatus(offer model.Offer, found bool) string {
+	switch {
+	case !found:
+		return "missing_upstream"
+	case offer.Excluded:
+		return "excluded"
+	}
+	return string(offer.Status)
+}
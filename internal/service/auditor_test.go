@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/offer_service"
+	"offer-read-service/internal/model"
+	"reflect"
+	"testing"
+)
+
+type auditOfferRepository struct {
+	storedOfferRepository
+	sample []string
+}
+
+func (r *auditOfferRepository) SampleOfferCodes(context.Context, int) ([]string, error) {
+	return r.sample, nil
+}
+
+type excludingEnricher struct {
+	statusEnricher
+	excluded map[string]bool
+}
+
+func (e excludingEnricher) Enrich(ctx context.Context, offers []*offer_service.Offer) ([]model.Offer, error) {
+	res, err := e.statusEnricher.Enrich(ctx, offers)
+	for i := range res {
+		res[i].Excluded = e.excluded[res[i].Code]
+	}
+	return res, err
+}
+
+func TestAuditor_Audit(t *testing.T) {
+	tests := []struct {
+		name           string
+		stored         []model.Offer
+		computed       statusEnricher
+		excluded       map[string]bool
+		missing        map[string]bool
+		wantMismatched int
+		wantFixed      []string
+	}{
+		{
+			name:     "matching",
+			stored:   []model.Offer{{Code: "A", Status: model.OfferStatusCodeSales}},
+			computed: statusEnricher{"A": model.OfferStatusCodeSales},
+		},
+		{
+			name:           "status_mismatch",
+			stored:         []model.Offer{{Code: "A", Status: model.OfferStatusCodeSales}},
+			computed:       statusEnricher{"A": model.OfferStatusCodeSold},
+			wantMismatched: 1,
+			wantFixed:      []string{"A"},
+		},
+		{
+			name:           "missing_upstream",
+			stored:         []model.Offer{{Code: "A", Status: model.OfferStatusCodeSales}},
+			computed:       statusEnricher{"A": model.OfferStatusCodeSales},
+			missing:        map[string]bool{"A": true},
+			wantMismatched: 1,
+		},
+		{
+			name:           "missing_in_index",
+			computed:       statusEnricher{"A": model.OfferStatusCodeSales},
+			wantMismatched: 1,
+			wantFixed:      []string{"A"},
+		},
+		{
+			name:     "excluded_and_absent",
+			computed: statusEnricher{"A": model.OfferStatusCodeSales},
+			excluded: map[string]bool{"A": true},
+		},
+		{
+			name:           "excluded_but_indexed",
+			stored:         []model.Offer{{Code: "A", Status: model.OfferStatusCodeSales}},
+			computed:       statusEnricher{"A": model.OfferStatusCodeSales},
+			excluded:       map[string]bool{"A": true},
+			wantMismatched: 1,
+			wantFixed:      []string{"A"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &auditOfferRepository{storedOfferRepository: storedOfferRepository{stored: tt.stored}, sample: []string{"A"}}
+			s := NewAuditor(codesOfferClient{missing: tt.missing}, repo, excludingEnricher{statusEnricher: tt.computed, excluded: tt.excluded}, 1, true)
+
+			result, err := s.Audit(context.Background())
+			if err != nil {
+				t.Fatalf("Audit() error = %v", err)
+			}
+			if result.NumAudited != 1 {
+				t.Errorf("NumAudited got = %v, want 1", result.NumAudited)
+			}
+			if result.NumMismatched != tt.wantMismatched {
+				t.Errorf("NumMismatched got = %v, want %v", result.NumMismatched, tt.wantMismatched)
+			}
+			if !reflect.DeepEqual(repo.updated, tt.wantFixed) || result.NumFixed != len(tt.wantFixed) {
+				t.Errorf("fixed got = %v, want %v", repo.updated, tt.wantFixed)
+			}
+		})
+	}
+}
+
+func TestAuditor_Audit_resetsMismatchRate(t *testing.T) {
+	repo := &auditOfferRepository{storedOfferRepository: storedOfferRepository{stored: []model.Offer{{Code: "A", Status: model.OfferStatusCodeSales}}}, sample: []string{"A"}}
+	if _, err := NewAuditor(codesOfferClient{}, repo, statusEnricher{"A": model.OfferStatusCodeSold}, 1, false).Audit(context.Background()); err != nil {
+		t.Fatalf("Audit() error = %v", err)
+	}
+	repo.stored = []model.Offer{{Code: "A", Status: model.OfferStatusCodeSold}}
+	if _, err := NewAuditor(codesOfferClient{}, repo, statusEnricher{"A": model.OfferStatusCodeSold}, 1, false).Audit(context.Background()); err != nil {
+		t.Fatalf("Audit() error = %v", err)
+	}
+	if count := testutil.CollectAndCount(mismatchRate); count != 1 {
+		t.Errorf("mismatch_rate series = %v, want only the status of the last sample", count)
+	}
+	if rate := testutil.ToFloat64(mismatchRate.WithLabelValues(string(model.OfferStatusCodeSold))); rate != 0 {
+		t.Errorf("mismatch_rate{sold} = %v, want 0", rate)
+	}
+}
@@ -0,0 +1,180 @@
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/samber/lo"
+	"gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/offer_service"
+	"offer-read-service/internal/model"
+	"offer-read-service/internal/ratelimit"
+)
+
+const dryRunSampleSize = 20
+
+// dryRunExcluded is the target of the transitions of the offers excluded by the status overrides.
+const dryRunExcluded model.OfferStatusCode = "excluded"
+
+type StatusTransition struct {
+	From       model.OfferStatusCode `json:"from"`
+	To         model.OfferStatusCode `json:"to"`
+	Count      int                   `json:"count"`
+	OfferCodes []string              `json:"sample_offer_codes"`
+}
+
+type DryRunReport struct {
+	Scope       ReindexScope       `json:"scope"`
+	StartedAt   time.Time          `json:"started_at"`
+	FinishedAt  time.Time          `json:"finished_at"`
+	NumComputed int                `json:"num_computed"`
+	NumChanged  int                `json:"num_changed"`
+	NumMissing  int                `json:"num_missing"`
+	NumExcluded int                `json:"num_excluded"`
+	Transitions []StatusTransition `json:"transitions"`
+}
+
+func (r DryRunReport) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	err := writer.Write([]string{"from", "to", "count", "sample_offer_codes"})
+	if err != nil {
+		return err
+	}
+	for _, transition := range r.Transitions {
+		err = writer.Write([]string{
+			string(transition.From),
+			string(transition.To),
+			strconv.Itoa(transition.Count),
+			strings.Join(transition.OfferCodes, " "),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+type diffCollector struct {
+	report      DryRunReport
+	transitions map[[2]model.OfferStatusCode]*StatusTransition
+}
+
+func newDiffCollector(scope ReindexScope) *diffCollector {
+	return &diffCollector{
+		report:      DryRunReport{Scope: scope, StartedAt: time.Now()},
+		transitions: map[[2]model.OfferStatusCode]*StatusTransition{},
+	}
+}
+
+// add accounts a computed offer against its stored document, stored is nil for offers missing in the index.
+// An excluded offer changes the index only if it is stored, it is removed then.
+func (c *diffCollector) add(stored *model.Offer, computed model.Offer) {
+	c.report.NumComputed++
+	var from model.OfferStatusCode
+	if stored != nil {
+		from = stored.Status
+	}
+	to := computed.Status
+	switch {
+	case computed.Excluded:
+		c.report.NumExcluded++
+		to = dryRunExcluded
+		if stored != nil {
+			c.report.NumChanged++
+		}
+	case stored == nil:
+		c.report.NumMissing++
+		c.report.NumChanged++
+	case from != to:
+		c.report.NumChanged++
+	}
+
+	key := [2]model.OfferStatusCode{from, to}
+	transition, ok := c.transitions[key]
+	if !ok {
+		transition = &StatusTransition{From: from, To: to}
+		c.transitions[key] = transition
+	}
+	transition.Count++
+	if len(transition.OfferCodes) < dryRunSampleSize {
+		transition.OfferCodes = append(transition.OfferCodes, computed.Code)
+	}
+}
+
+func (c *diffCollector) result() DryRunReport {
+	report := c.report
+	report.FinishedAt = time.Now()
+	report.Transitions = lo.Map(lo.Values(c.transitions), func(item *StatusTransition, _ int) StatusTransition {
+		return *item
+	})
+	sort.Slice(report.Transitions, func(i, j int) bool {
+		if report.Transitions[i].Count != report.Transitions[j].Count {
+			return report.Transitions[i].Count > report.Transitions[j].Count
+		}
+		if report.Transitions[i].From != report.Transitions[j].From {
+			return report.Transitions[i].From < report.Transitions[j].From
+		}
+		return report.Transitions[i].To < report.Transitions[j].To
+	})
+	return report
+}
+
+func (s *indexator) DryRun(ctx context.Context, scope ReindexScope) (DryRunReport, error) {
+	ctx = ratelimit.WithLimits(ctx)
+	logger := ctxzap.Extract(ctx)
+	collector := newDiffCollector(scope)
+
+	diff := func(offers []*offer_service.Offer) error {
+		richOffers, err := s.offerEnricher.Enrich(ctx, offers)
+		if err != nil {
+			return fmt.Errorf("can't enrich %w", err)
+		}
+		storedOffers, err := s.offerRepository.GetOffers(ctx, lo.Map(richOffers, func(item model.Offer, _ int) string {
+			return item.Code
+		}))
+		if err != nil {
+			return fmt.Errorf("can't get offers from elastic %w", err)
+		}
+		stored := lo.SliceToMap(storedOffers, func(item model.Offer) (string, model.Offer) {
+			return item.Code, item
+		})
+		for _, offer := range richOffers {
+			if storedOffer, ok := stored[offer.Code]; ok {
+				collector.add(&storedOffer, offer)
+			} else {
+				collector.add(nil, offer)
+			}
+		}
+		return nil
+	}
+
+	var err error
+	if scope.IsEmpty() {
+		_, err = s.forEachOfferPage(ctx, 0, func(page int, offers []*offer_service.Offer) error {
+			if page%10 == 0 || page == 1 {
+				logger.Sugar().Infof("dry run page=%d", page)
+			}
+			return diff(offers)
+		})
+	} else {
+		err = s.forEachScopeChunk(ctx, scope, func(offerCodes []string) error {
+			offers, err := searchOffersByCodes(ctx, s.offerClient, offerCodes)
+			if err != nil {
+				return err
+			}
+			return diff(offers)
+		})
+	}
+	if err != nil {
+		return DryRunReport{}, err
+	}
+
+	return collector.result(), nil
+}
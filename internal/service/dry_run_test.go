@@ -0,0 +1,143 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"github.com/samber/lo"
+	"gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/offer_service"
+	"google.golang.org/grpc"
+	"offer-read-service/internal/model"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+type statusEnricher map[string]model.OfferStatusCode
+
+func (e statusEnricher) Enrich(_ context.Context, offers []*offer_service.Offer) ([]model.Offer, error) {
+	return lo.Map(offers, func(offer *offer_service.Offer, _ int) model.Offer {
+		return model.Offer{Code: offer.OfferCode, Status: e[offer.OfferCode]}
+	}), nil
+}
+
+type codesOfferClient struct {
+	offer_service.OfferServiceClient
+	missing map[string]bool
+}
+
+func (c codesOfferClient) SearchOffers(_ context.Context, in *offer_service.SearchOffersRequest, _ ...grpc.CallOption) (*offer_service.SearchOffersResponse, error) {
+	codes := lo.Reject(in.OfferCodes, func(code string, _ int) bool {
+		return c.missing[code]
+	})
+	return &offer_service.SearchOffersResponse{Offer: lo.Map(codes, func(code string, _ int) *offer_service.Offer {
+		return &offer_service.Offer{OfferCode: code}
+	})}, nil
+}
+
+func TestIndexator_DryRun(t *testing.T) {
+	tests := []struct {
+		name            string
+		computed        statusEnricher
+		excluded        map[string]bool
+		stored          []model.Offer
+		wantChanged     int
+		wantMissing     int
+		wantExcluded    int
+		wantTransitions []StatusTransition
+	}{
+		{
+			name:     "unchanged",
+			computed: statusEnricher{"OFFER-CODE-1": model.OfferStatusCodeSales},
+			stored:   []model.Offer{{Code: "OFFER-CODE-1", Status: model.OfferStatusCodeSales}},
+			wantTransitions: []StatusTransition{
+				{From: model.OfferStatusCodeSales, To: model.OfferStatusCodeSales, Count: 1, OfferCodes: []string{"OFFER-CODE-1"}},
+			},
+		},
+		{
+			name: "changed_and_missing",
+			computed: statusEnricher{
+				"OFFER-CODE-1": model.OfferStatusCodeSold,
+				"OFFER-CODE-2": model.OfferStatusCodeSold,
+				"OFFER-CODE-3": model.OfferStatusCodeNew,
+			},
+			stored: []model.Offer{
+				{Code: "OFFER-CODE-1", Status: model.OfferStatusCodeInOrder},
+				{Code: "OFFER-CODE-2", Status: model.OfferStatusCodeInOrder},
+			},
+			wantChanged: 3,
+			wantMissing: 1,
+			wantTransitions: []StatusTransition{
+				{From: model.OfferStatusCodeInOrder, To: model.OfferStatusCodeSold, Count: 2, OfferCodes: []string{"OFFER-CODE-1", "OFFER-CODE-2"}},
+				{From: "", To: model.OfferStatusCodeNew, Count: 1, OfferCodes: []string{"OFFER-CODE-3"}},
+			},
+		},
+		{
+			name: "excluded",
+			computed: statusEnricher{
+				"OFFER-CODE-1": model.OfferStatusCodeSales,
+				"OFFER-CODE-2": model.OfferStatusCodeNew,
+			},
+			excluded: map[string]bool{"OFFER-CODE-1": true, "OFFER-CODE-2": true},
+			stored:   []model.Offer{{Code: "OFFER-CODE-1", Status: model.OfferStatusCodeSales}},
+			// the stored offer is removed, the missing one stays absent
+			wantChanged:  1,
+			wantExcluded: 2,
+			wantTransitions: []StatusTransition{
+				{From: "", To: dryRunExcluded, Count: 1, OfferCodes: []string{"OFFER-CODE-2"}},
+				{From: model.OfferStatusCodeSales, To: dryRunExcluded, Count: 1, OfferCodes: []string{"OFFER-CODE-1"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			offerRepo := &storedOfferRepository{stored: tt.stored}
+			s := &indexator{
+				offerEnricher:   excludingEnricher{statusEnricher: tt.computed, excluded: tt.excluded},
+				offerClient:     codesOfferClient{},
+				offerRepository: offerRepo,
+				perPage:         10,
+			}
+			offerCodes := lo.Keys(tt.computed)
+			sort.Strings(offerCodes)
+
+			report, err := s.DryRun(context.Background(), ReindexScope{OfferCodes: offerCodes})
+			if err != nil {
+				t.Fatalf("DryRun() error = %v", err)
+			}
+			if len(offerRepo.updated) > 0 {
+				t.Errorf("DryRun() updated %v, want no writes", offerRepo.updated)
+			}
+			if report.NumComputed != len(tt.computed) {
+				t.Errorf("NumComputed got = %v, want %v", report.NumComputed, len(tt.computed))
+			}
+			if report.NumChanged != tt.wantChanged {
+				t.Errorf("NumChanged got = %v, want %v", report.NumChanged, tt.wantChanged)
+			}
+			if report.NumMissing != tt.wantMissing {
+				t.Errorf("NumMissing got = %v, want %v", report.NumMissing, tt.wantMissing)
+			}
+			if report.NumExcluded != tt.wantExcluded {
+				t.Errorf("NumExcluded got = %v, want %v", report.NumExcluded, tt.wantExcluded)
+			}
+			if !reflect.DeepEqual(report.Transitions, tt.wantTransitions) {
+				t.Errorf("Transitions got = %+v, want %+v", report.Transitions, tt.wantTransitions)
+			}
+		})
+	}
+}
+
+func TestDryRunReport_WriteCSV(t *testing.T) {
+	report := DryRunReport{
+		Transitions: []StatusTransition{
+			{From: model.OfferStatusCodeInOrder, To: model.OfferStatusCodeSold, Count: 2, OfferCodes: []string{"OFFER-CODE-1", "OFFER-CODE-2"}},
+		},
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := report.WriteCSV(buf); err != nil {
+		t.Fatalf("WriteCSV() error = %v", err)
+	}
+	want := "from,to,count,sample_offer_codes\nin_order,sold,2,OFFER-CODE-1 OFFER-CODE-2\n"
+	if buf.String() != want {
+		t.Errorf("WriteCSV() got = %q, want %q", buf.String(), want)
+	}
+}
@@ -2,83 +2,563 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"github.com/avast/retry-go"
 	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
 	"github.com/samber/lo"
 	"gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/offer_service"
+	"gitlab.int.tsum.com/preowned/simona/delta/core.git/custom_error"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"offer-read-service/internal/health"
+	"offer-read-service/internal/model"
+	"offer-read-service/internal/ratelimit"
 	"offer-read-service/internal/repository"
-	"sync"
 	"time"
 )
 
+const deadLetterRetryLimit = 10000
+
+var ErrIndexingInProgress = errors.New("indexing is already started")
+
+// errPageFailed marks the batch which failed as a whole because of a dependency, not because of its offers.
+var errPageFailed = errors.New("page failed")
+
 type IndexingResult struct {
-	NumIndexed int
-	Elapsed    time.Duration
+	NumIndexed  int
+	NumFailed   int
+	FailedPages int
+	Elapsed     time.Duration
+}
+
+type ReindexScope struct {
+	SellerIDs  []int64                 `json:"seller_ids"`
+	OfferCodes []string                `json:"offer_codes"`
+	ItemCodes  []string                `json:"item_codes"`
+	Statuses   []model.OfferStatusCode `json:"statuses"`
+}
+
+func (s ReindexScope) IsEmpty() bool {
+	return len(s.SellerIDs) == 0 && len(s.OfferCodes) == 0 && len(s.ItemCodes) == 0 && len(s.Statuses) == 0
+}
+
+func (s ReindexScope) onlyOfferCodes() bool {
+	return len(s.OfferCodes) > 0 && len(s.SellerIDs) == 0 && len(s.ItemCodes) == 0 && len(s.Statuses) == 0
+}
+
+func (s ReindexScope) toFilter() repository.OfferFilter {
+	return repository.OfferFilter{
+		Codes:     s.OfferCodes,
+		SellerIDs: s.SellerIDs,
+		ItemCodes: s.ItemCodes,
+		Statuses:  s.Statuses,
+	}
+}
+
+type OfferReindexStatus string
+
+const (
+	OfferReindexStatusIndexed  OfferReindexStatus = "indexed"
+	OfferReindexStatusNotFound OfferReindexStatus = "not_found"
+	OfferReindexStatusSkipped  OfferReindexStatus = "skipped"
+	OfferReindexStatusFailed   OfferReindexStatus = "failed"
+)
+
+type OfferReindexResult struct {
+	OfferCode string                `json:"offer_code"`
+	Status    OfferReindexStatus    `json:"status"`
+	NewStatus model.OfferStatusCode `json:"new_status,omitempty"`
+	Error     string                `json:"error,omitempty"`
+}
+
+type ReindexResult struct {
+	IndexingResult
+	Offers []OfferReindexResult
 }
 
 type Indexator interface {
 	Index(ctx context.Context) (IndexingResult, error)
+	// IndexNew indexes the offers created after the newest offer in the index.
+	IndexNew(ctx context.Context) (IndexingResult, error)
+	Reindex(ctx context.Context, scope ReindexScope) (ReindexResult, error)
+	ScopeSize(ctx context.Context, scope ReindexScope) (int64, error)
+	DryRun(ctx context.Context, scope ReindexScope) (DryRunReport, error)
+	RetryDeadLetters(ctx context.Context) (ReindexResult, error)
+	LockHolder(ctx context.Context) (*model.Lease, error)
+}
+
+type RetryPolicy struct {
+	Attempts       uint
+	Delay          time.Duration
+	MaxFailedPages int
 }
 
 type indexator struct {
-	offerEnricher   OfferEnricher
-	lock            sync.Mutex
-	offerClient     offer_service.OfferServiceClient
-	offerRepository repository.OfferRepository
-	perPage         int
+	offerEnricher        OfferEnricher
+	locker               Locker
+	offerClient          offer_service.OfferServiceClient
+	offerRepository      repository.OfferRepository
+	deadLetterRepository repository.DeadLetterRepository
+	perPage              int
+	retryPolicy          RetryPolicy
+	dependencies         *health.Monitor
 }
 
-func NewIndexator(offerClient offer_service.OfferServiceClient, repo repository.OfferRepository, perPage int, offerEnricher OfferEnricher) Indexator {
+func NewIndexator(offerClient offer_service.OfferServiceClient, repo repository.OfferRepository, deadLetterRepo repository.DeadLetterRepository, perPage int, offerEnricher OfferEnricher, retryPolicy RetryPolicy, locker Locker, dependencies *health.Monitor) Indexator {
 	return &indexator{
-		lock:            sync.Mutex{},
-		offerClient:     offerClient,
-		offerRepository: repo,
-		perPage:         perPage,
-		offerEnricher:   offerEnricher,
+		locker:               locker,
+		offerClient:          offerClient,
+		offerRepository:      repo,
+		deadLetterRepository: deadLetterRepo,
+		perPage:              perPage,
+		offerEnricher:        offerEnricher,
+		retryPolicy:          retryPolicy,
+		dependencies:         dependencies,
 	}
 }
 
 func (s *indexator) Index(ctx context.Context) (IndexingResult, error) {
-	logger := ctxzap.Extract(ctx)
-	if !s.lock.TryLock() {
-		return IndexingResult{}, fmt.Errorf("indexing is already started")
+	return s.index(ctx, false)
+}
+
+// IndexNew relies on the offer ids growing with time: the offer service has no filter by update time,
+// changes of the indexed offers come with the Kafka events and the full indexing.
+func (s *indexator) IndexNew(ctx context.Context) (IndexingResult, error) {
+	return s.index(ctx, true)
+}
+
+func (s *indexator) index(ctx context.Context, onlyNew bool) (IndexingResult, error) {
+	ctx = ratelimit.WithLimits(ctx)
+	ctx, unlock, err := s.locker.Lock(ctx)
+	if err != nil {
+		return IndexingResult{}, err
 	}
-	defer s.lock.Unlock()
+	defer unlock()
+	var afterID int64
+	if onlyNew {
+		afterID, err = s.offerRepository.LastOfferID(ctx)
+		if err != nil {
+			return IndexingResult{}, fmt.Errorf("offerRepository.LastOfferID %w", err)
+		}
+	}
+	logger := ctxzap.Extract(ctx)
 	started := time.Now()
-	numIndexed := 0
+	result := IndexingResult{}
+	failedPages, err := s.forEachOfferPage(ctx, afterID, func(page int, offers []*offer_service.Offer) error {
+		richOffers, failed, err := s.indexOffers(ctx, offers)
+		if err != nil {
+			return err
+		}
+		if page%10 == 0 || page == 1 {
+			logger.Sugar().Infof("page=%d", page)
+		}
+		result.NumIndexed += len(richOffers)
+		result.NumFailed += len(failed)
+		return nil
+	})
+	if err != nil {
+		return IndexingResult{}, err
+	}
+
+	result.FailedPages = failedPages
+	result.Elapsed = time.Since(started)
+	return result, nil
+}
+
+// forEachOfferPage skips pages that could not be fetched or indexed (fn fails with errPageFailed) after retries
+// and gives up after MaxFailedPages consecutive failures.
+// Every page waits for the dependencies to be healthy, so an outage pauses the indexing instead of failing the pages.
+// A positive afterID limits the pages to the offers with greater ids, the pages are sorted by id descending.
+func (s *indexator) forEachOfferPage(ctx context.Context, afterID int64, fn func(page int, offers []*offer_service.Offer) error) (int, error) {
+	logger := ctxzap.Extract(ctx)
+	failedPages, consecutiveFailedPages := 0, 0
 	for page := 1; ; page++ {
-		offers, err := s.offerClient.SearchOffers(ctx, &offer_service.SearchOffersRequest{
-			Pagination: &offer_service.Pagination{
-				Limit:  lo.ToPtr(int32(s.perPage)),
-				Offset: lo.ToPtr(int32((page - 1) * s.perPage)),
-			},
-			Sort: &offer_service.Sort{
-				Field:     offer_service.SortField_ID,
-				Direction: offer_service.SortDirection_DESC,
-			},
-			PriceFilter: offer_service.OfferPriceFilter_OFFER_PRICE_FILTER_WITH_EMPTY_PRICE,
+		if err := s.dependencies.Wait(ctx); err != nil {
+			return failedPages, err
+		}
+		var offers *offer_service.SearchOffersResponse
+		err := s.withRetry(ctx, func() error {
+			var err error
+			offers, err = s.offerClient.SearchOffers(ctx, &offer_service.SearchOffersRequest{
+				Pagination: &offer_service.Pagination{
+					Limit:  lo.ToPtr(int32(s.perPage)),
+					Offset: lo.ToPtr(int32((page - 1) * s.perPage)),
+				},
+				Sort: &offer_service.Sort{
+					Field:     offer_service.SortField_ID,
+					Direction: offer_service.SortDirection_DESC,
+				},
+				PriceFilter: offer_service.OfferPriceFilter_OFFER_PRICE_FILTER_WITH_EMPTY_PRICE,
+			})
+			return err
 		})
 		if err != nil {
-			return IndexingResult{}, fmt.Errorf("can't SearchOffers %w", err)
+			if ctx.Err() != nil {
+				return failedPages, ctx.Err()
+			}
+			failedPages++
+			consecutiveFailedPages++
+			if consecutiveFailedPages >= s.retryPolicy.MaxFailedPages {
+				return failedPages, fmt.Errorf("can't SearchOffers %w", err)
+			}
+			logger.Error("can't SearchOffers, page is skipped", zap.Int("page", page), zap.Error(err))
+			continue
+		}
+
+		newOffers := lo.Filter(offers.Offer, func(item *offer_service.Offer, _ int) bool {
+			return item.Id > afterID
+		})
+		if len(newOffers) > 0 {
+			err = fn(page, newOffers)
+		}
+		switch {
+		case errors.Is(err, errPageFailed):
+			failedPages++
+			consecutiveFailedPages++
+			if consecutiveFailedPages >= s.retryPolicy.MaxFailedPages {
+				return failedPages, err
+			}
+			logger.Error("can't index offers, page is skipped", zap.Int("page", page), zap.Error(err))
+		case err != nil:
+			return failedPages, err
+		default:
+			consecutiveFailedPages = 0
+		}
+		if len(offers.Offer) < s.perPage || len(newOffers) < len(offers.Offer) {
+			return failedPages, nil
+		}
+	}
+}
+
+func (s *indexator) withRetry(ctx context.Context, fn func() error) error {
+	return retry.Do(
+		fn,
+		retry.Context(ctx),
+		retry.Attempts(s.retryPolicy.Attempts),
+		retry.Delay(s.retryPolicy.Delay),
+		retry.DelayType(retry.BackOffDelay),
+		retry.LastErrorOnly(true),
+	)
+}
+
+func (s *indexator) enrichAndUpdate(ctx context.Context, offers []*offer_service.Offer) ([]model.Offer, error) {
+	richOffers, err := s.offerEnricher.Enrich(ctx, offers)
+	if err != nil {
+		return nil, fmt.Errorf("can't enrich %w", err)
+	}
+
+	err = s.offerRepository.Update(ctx, richOffers)
+	if err != nil {
+		return nil, fmt.Errorf("can't update in elastic %w", err)
+	}
+	return richOffers, nil
+}
+
+// indexOffers retries the whole batch and, if it still fails, bisects it to index what it can,
+// failing offers are put to the dead-letter list.
+func (s *indexator) indexOffers(ctx context.Context, offers []*offer_service.Offer) ([]model.Offer, []model.DeadLetter, error) {
+	var richOffers []model.Offer
+	err := s.withRetry(ctx, func() error {
+		var err error
+		richOffers, err = s.enrichAndUpdate(ctx, offers)
+		return err
+	})
+	if err == nil {
+		return richOffers, nil, nil
+	}
+	if ctx.Err() != nil {
+		return nil, nil, ctx.Err()
+	}
+	// bisecting won't help if a dependency fails every call
+	if !isOfferSpecific(err) {
+		return nil, nil, fmt.Errorf("%w: %w", errPageFailed, err)
+	}
+
+	richOffers, failed := s.isolateFailures(ctx, offers, err)
+	if ctx.Err() != nil {
+		return nil, nil, ctx.Err()
+	}
+	s.addDeadLetters(ctx, failed)
+	return richOffers, failed, nil
+}
+
+func (s *indexator) isolateFailures(ctx context.Context, offers []*offer_service.Offer, err error) ([]model.Offer, []model.DeadLetter) {
+	if len(offers) == 1 || !isOfferSpecific(err) {
+		return nil, lo.Map(offers, func(offer *offer_service.Offer, _ int) model.DeadLetter {
+			return model.DeadLetter{OfferCode: offer.OfferCode, Reason: err.Error(), FailedAt: time.Now()}
+		})
+	}
+
+	var indexed []model.Offer
+	var failed []model.DeadLetter
+	for _, half := range [][]*offer_service.Offer{offers[:len(offers)/2], offers[len(offers)/2:]} {
+		richOffers, err := s.enrichAndUpdate(ctx, half)
+		if err == nil {
+			indexed = append(indexed, richOffers...)
+			continue
+		}
+		if ctx.Err() != nil {
+			return indexed, failed
 		}
+		halfIndexed, halfFailed := s.isolateFailures(ctx, half, err)
+		indexed = append(indexed, halfIndexed...)
+		failed = append(failed, halfFailed...)
+	}
+	return indexed, failed
+}
+
+// isOfferSpecific reports whether the error is caused by the offers themselves: an invalid request
+// to an upstream or a rejected document. Other errors mean an unavailable dependency.
+func isOfferSpecific(err error) bool {
+	var invalidArgument *custom_error.InvalidArgument
+	if errors.As(err, &invalidArgument) {
+		return true
+	}
+	var grpcErr interface{ GRPCStatus() *status.Status }
+	if !errors.As(err, &grpcErr) {
+		return false
+	}
+	switch grpcErr.GRPCStatus().Code() {
+	case codes.InvalidArgument, codes.NotFound, codes.FailedPrecondition, codes.OutOfRange:
+		return true
+	}
+	return false
+}
+
+func (s *indexator) addDeadLetters(ctx context.Context, deadLetters []model.DeadLetter) {
+	if len(deadLetters) == 0 {
+		return
+	}
+	logger := ctxzap.Extract(ctx)
+	for _, deadLetter := range deadLetters {
+		logger.Error("offer is moved to dead letters", zap.String("offer_code", deadLetter.OfferCode), zap.String("reason", deadLetter.Reason))
+	}
+	if err := s.deadLetterRepository.Add(ctx, deadLetters); err != nil {
+		logger.Error("can't add dead letters", zap.Error(err))
+	}
+}
+
+func (s *indexator) LockHolder(ctx context.Context) (*model.Lease, error) {
+	return s.locker.Holder(ctx)
+}
+
+func (s *indexator) ScopeSize(ctx context.Context, scope ReindexScope) (int64, error) {
+	if scope.IsEmpty() {
+		return 0, &custom_error.InvalidArgument{Message: "reindex scope is empty"}
+	}
+	if scope.onlyOfferCodes() {
+		return int64(len(lo.Uniq(scope.OfferCodes))), nil
+	}
+	if err := s.checkItemCodesIndexed(ctx, scope); err != nil {
+		return 0, err
+	}
+	count, err := s.offerRepository.CountOffers(ctx, scope.toFilter())
+	if err != nil {
+		return 0, fmt.Errorf("offerRepository.CountOffers %w", err)
+	}
+	return count, nil
+}
 
-		richOffers, err := s.offerEnricher.Enrich(ctx, offers.Offer)
+func (s *indexator) Reindex(ctx context.Context, scope ReindexScope) (ReindexResult, error) {
+	ctx = ratelimit.WithLimits(ctx)
+	if scope.IsEmpty() {
+		return ReindexResult{}, &custom_error.InvalidArgument{Message: "reindex scope is empty"}
+	}
+	ctx, unlock, err := s.locker.Lock(ctx)
+	if err != nil {
+		return ReindexResult{}, err
+	}
+	defer unlock()
+	return s.reindex(ctx, scope)
+}
+
+func (s *indexator) reindex(ctx context.Context, scope ReindexScope) (ReindexResult, error) {
+	started := time.Now()
+	result := ReindexResult{}
+	err := s.forEachScopeChunk(ctx, scope, func(offerCodes []string) error {
+		offers, err := s.reindexOfferCodes(ctx, offerCodes)
 		if err != nil {
-			return IndexingResult{}, fmt.Errorf("can't enrich %w", err)
+			return err
+		}
+		result.Offers = append(result.Offers, offers...)
+		result.NumIndexed += lo.CountBy(offers, func(item OfferReindexResult) bool {
+			return item.Status == OfferReindexStatusIndexed
+		})
+		result.NumFailed += lo.CountBy(offers, func(item OfferReindexResult) bool {
+			return item.Status == OfferReindexStatusFailed
+		})
+		return nil
+	})
+	if err != nil {
+		return ReindexResult{}, err
+	}
+	result.Elapsed = time.Since(started)
+	return result, nil
+}
+
+// checkItemCodesIndexed fails the item code scope while some offers are indexed without the item code,
+// the scope would silently miss them. The offer service can't search by item codes, so there is no fallback.
+func (s *indexator) checkItemCodesIndexed(ctx context.Context, scope ReindexScope) error {
+	if len(scope.ItemCodes) == 0 {
+		return nil
+	}
+	count, err := s.offerRepository.CountOffers(ctx, repository.OfferFilter{
+		Codes:           scope.OfferCodes,
+		SellerIDs:       scope.SellerIDs,
+		Statuses:        scope.Statuses,
+		WithoutItemCode: true,
+	})
+	if err != nil {
+		return fmt.Errorf("offerRepository.CountOffers %w", err)
+	}
+	if count > 0 {
+		return &custom_error.InvalidArgument{Message: fmt.Sprintf("%d offers are indexed without item code, run the full index before reindexing by item codes", count)}
+	}
+	return nil
+}
+
+func (s *indexator) forEachScopeChunk(ctx context.Context, scope ReindexScope, fn func(offerCodes []string) error) error {
+	if scope.onlyOfferCodes() {
+		for _, chunk := range lo.Chunk(lo.Uniq(scope.OfferCodes), s.perPage) {
+			if err := s.dependencies.Wait(ctx); err != nil {
+				return err
+			}
+			if err := fn(chunk); err != nil {
+				return err
+			}
 		}
+		return nil
+	}
 
-		err = s.offerRepository.Update(ctx, richOffers)
+	if err := s.checkItemCodesIndexed(ctx, scope); err != nil {
+		return err
+	}
+	searchAfter := ""
+	for {
+		if err := s.dependencies.Wait(ctx); err != nil {
+			return err
+		}
+		var offerCodes []string
+		err := s.withRetry(ctx, func() error {
+			var err error
+			offerCodes, err = s.offerRepository.ListOfferCodes(ctx, scope.toFilter(), searchAfter, s.perPage)
+			return err
+		})
 		if err != nil {
-			return IndexingResult{}, fmt.Errorf("can't update in elastic %w", err)
+			return fmt.Errorf("can't ListOfferCodes %w", err)
 		}
-		if page%10 == 0 || page == 1 {
-			logger.Sugar().Infof("page=%d", page)
+		if len(offerCodes) > 0 {
+			if err = fn(offerCodes); err != nil {
+				return err
+			}
+		}
+		if len(offerCodes) < s.perPage {
+			return nil
+		}
+		searchAfter = offerCodes[len(offerCodes)-1]
+	}
+}
+
+func searchOffersByCodes(ctx context.Context, offerClient offer_service.OfferServiceClient, offerCodes []string) ([]*offer_service.Offer, error) {
+	offers, err := offerClient.SearchOffers(ctx, &offer_service.SearchOffersRequest{
+		Pagination: &offer_service.Pagination{
+			Limit: lo.ToPtr(int32(len(offerCodes))),
+		},
+		OfferCodes:  offerCodes,
+		PriceFilter: offer_service.OfferPriceFilter_OFFER_PRICE_FILTER_WITH_EMPTY_PRICE,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't SearchOffers %w", err)
+	}
+	return offers.Offer, nil
+}
+
+func (s *indexator) reindexOfferCodes(ctx context.Context, offerCodes []string) ([]OfferReindexResult, error) {
+	var offers []*offer_service.Offer
+	err := s.withRetry(ctx, func() error {
+		var err error
+		offers, err = searchOffersByCodes(ctx, s.offerClient, offerCodes)
+		return err
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
 		}
-		numIndexed += len(richOffers)
-		if len(offers.Offer) < s.perPage {
-			break
+		return s.failAll(ctx, offerCodes, err), nil
+	}
+
+	richOffers, failedOffers, err := s.indexOffers(ctx, offers)
+	if errors.Is(err, errPageFailed) {
+		return s.failAll(ctx, offerCodes, err), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	found := lo.SliceToMap(offers, func(item *offer_service.Offer) (string, struct{}) {
+		return item.OfferCode, struct{}{}
+	})
+	indexed := lo.SliceToMap(richOffers, func(item model.Offer) (string, model.Offer) {
+		return item.Code, item
+	})
+	failed := lo.SliceToMap(failedOffers, func(item model.DeadLetter) (string, model.DeadLetter) {
+		return item.OfferCode, item
+	})
+	return lo.Map(offerCodes, func(offerCode string, _ int) OfferReindexResult {
+		if offer, ok := indexed[offerCode]; ok {
+			return OfferReindexResult{OfferCode: offerCode, Status: OfferReindexStatusIndexed, NewStatus: offer.Status}
 		}
+		if deadLetter, ok := failed[offerCode]; ok {
+			return OfferReindexResult{OfferCode: offerCode, Status: OfferReindexStatusFailed, Error: deadLetter.Reason}
+		}
+		if _, ok := found[offerCode]; ok {
+			return OfferReindexResult{OfferCode: offerCode, Status: OfferReindexStatusSkipped}
+		}
+		return OfferReindexResult{OfferCode: offerCode, Status: OfferReindexStatusNotFound}
+	}), nil
+}
+
+// failAll puts all the offers of the chunk to the dead-letter list.
+func (s *indexator) failAll(ctx context.Context, offerCodes []string, err error) []OfferReindexResult {
+	failed := lo.Map(offerCodes, func(offerCode string, _ int) model.DeadLetter {
+		return model.DeadLetter{OfferCode: offerCode, Reason: err.Error(), FailedAt: time.Now()}
+	})
+	s.addDeadLetters(ctx, failed)
+	return lo.Map(failed, func(item model.DeadLetter, _ int) OfferReindexResult {
+		return OfferReindexResult{OfferCode: item.OfferCode, Status: OfferReindexStatusFailed, Error: item.Reason}
+	})
+}
+
+func (s *indexator) RetryDeadLetters(ctx context.Context) (ReindexResult, error) {
+	ctx = ratelimit.WithLimits(ctx)
+	ctx, unlock, err := s.locker.Lock(ctx)
+	if err != nil {
+		return ReindexResult{}, err
+	}
+	defer unlock()
+
+	deadLetters, err := s.deadLetterRepository.List(ctx, deadLetterRetryLimit)
+	if err != nil {
+		return ReindexResult{}, fmt.Errorf("can't list dead letters %w", err)
+	}
+	if len(deadLetters) == 0 {
+		return ReindexResult{}, nil
+	}
+
+	result, err := s.reindex(ctx, ReindexScope{OfferCodes: lo.Map(deadLetters, func(item model.DeadLetter, _ int) string {
+		return item.OfferCode
+	})})
+	if err != nil {
+		return ReindexResult{}, err
 	}
 
-	return IndexingResult{NumIndexed: numIndexed, Elapsed: time.Since(started)}, nil
+	resolved := lo.FilterMap(result.Offers, func(item OfferReindexResult, _ int) (string, bool) {
+		return item.OfferCode, item.Status != OfferReindexStatusFailed
+	})
+	if err = s.deadLetterRepository.Delete(ctx, resolved); err != nil {
+		return result, fmt.Errorf("can't delete dead letters %w", err)
+	}
+	return result, nil
 }
@@ -0,0 +1,233 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/offer_service"
+	"gitlab.int.tsum.com/preowned/simona/delta/core.git/custom_error"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"offer-read-service/internal/model"
+	"offer-read-service/internal/repository"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+type failingEnricher struct {
+	failing     map[string]bool
+	unavailable bool
+}
+
+func (e failingEnricher) Enrich(_ context.Context, offers []*offer_service.Offer) ([]model.Offer, error) {
+	if e.unavailable {
+		return nil, status.Error(codes.Unavailable, "upstream is unavailable")
+	}
+	res := make([]model.Offer, 0, len(offers))
+	for _, offer := range offers {
+		if e.failing[offer.OfferCode] {
+			return nil, &custom_error.InvalidArgument{Message: "enrich failed"}
+		}
+		res = append(res, model.Offer{Code: offer.OfferCode})
+	}
+	return res, nil
+}
+
+type memoryOfferRepository struct {
+	repository.OfferRepository
+	updated []string
+}
+
+func (r *memoryOfferRepository) Update(_ context.Context, offers []model.Offer) error {
+	for _, offer := range offers {
+		r.updated = append(r.updated, offer.Code)
+	}
+	return nil
+}
+
+type memoryDeadLetterRepository struct {
+	repository.DeadLetterRepository
+	added []string
+}
+
+func (r *memoryDeadLetterRepository) Add(_ context.Context, deadLetters []model.DeadLetter) error {
+	for _, deadLetter := range deadLetters {
+		r.added = append(r.added, deadLetter.OfferCode)
+	}
+	return nil
+}
+
+func Test_indexOffers(t *testing.T) {
+	tests := []struct {
+		name        string
+		offerCodes  []string
+		failing     map[string]bool
+		unavailable bool
+		wantIndexed []string
+		wantFailed  []string
+		wantErr     error
+	}{
+		{
+			name:        "no_failures",
+			offerCodes:  []string{"OFFER-CODE-1", "OFFER-CODE-2", "OFFER-CODE-3"},
+			wantIndexed: []string{"OFFER-CODE-1", "OFFER-CODE-2", "OFFER-CODE-3"},
+		},
+		{
+			name:        "one_failing_offer",
+			offerCodes:  []string{"OFFER-CODE-1", "OFFER-CODE-2", "OFFER-CODE-3", "OFFER-CODE-4", "OFFER-CODE-5"},
+			failing:     map[string]bool{"OFFER-CODE-4": true},
+			wantIndexed: []string{"OFFER-CODE-1", "OFFER-CODE-2", "OFFER-CODE-3", "OFFER-CODE-5"},
+			wantFailed:  []string{"OFFER-CODE-4"},
+		},
+		{
+			name:       "all_failing",
+			offerCodes: []string{"OFFER-CODE-1", "OFFER-CODE-2"},
+			failing:    map[string]bool{"OFFER-CODE-1": true, "OFFER-CODE-2": true},
+			wantFailed: []string{"OFFER-CODE-1", "OFFER-CODE-2"},
+		},
+		{
+			name:        "dependency_failure_is_not_bisected",
+			offerCodes:  []string{"OFFER-CODE-1", "OFFER-CODE-2"},
+			unavailable: true,
+			wantErr:     errPageFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			offerRepo := &memoryOfferRepository{}
+			deadLetterRepo := &memoryDeadLetterRepository{}
+			s := &indexator{
+				offerEnricher:        failingEnricher{failing: tt.failing, unavailable: tt.unavailable},
+				offerRepository:      offerRepo,
+				deadLetterRepository: deadLetterRepo,
+				retryPolicy:          RetryPolicy{Attempts: 1},
+			}
+			offers := make([]*offer_service.Offer, 0, len(tt.offerCodes))
+			for _, offerCode := range tt.offerCodes {
+				offers = append(offers, &offer_service.Offer{OfferCode: offerCode})
+			}
+
+			richOffers, failed, err := s.indexOffers(context.Background(), offers)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("indexOffers() error = %v, want %v", err, tt.wantErr)
+			}
+			if len(richOffers) != len(tt.wantIndexed) {
+				t.Errorf("indexOffers() indexed = %v, want %v", len(richOffers), len(tt.wantIndexed))
+			}
+			if len(failed) != len(tt.wantFailed) {
+				t.Errorf("indexOffers() failed = %v, want %v", len(failed), len(tt.wantFailed))
+			}
+			sort.Strings(offerRepo.updated)
+			if !reflect.DeepEqual(offerRepo.updated, tt.wantIndexed) {
+				t.Errorf("updated got = %v, want %v", offerRepo.updated, tt.wantIndexed)
+			}
+			sort.Strings(deadLetterRepo.added)
+			if !reflect.DeepEqual(deadLetterRepo.added, tt.wantFailed) {
+				t.Errorf("dead letters got = %v, want %v", deadLetterRepo.added, tt.wantFailed)
+			}
+		})
+	}
+}
+
+type countingOfferRepository struct {
+	repository.OfferRepository
+	withoutItemCode int64
+	total           int64
+}
+
+func (r countingOfferRepository) CountOffers(_ context.Context, filter repository.OfferFilter) (int64, error) {
+	if filter.WithoutItemCode {
+		return r.withoutItemCode, nil
+	}
+	return r.total, nil
+}
+
+func Test_ScopeSize_itemCodes(t *testing.T) {
+	tests := []struct {
+		name            string
+		scope           ReindexScope
+		withoutItemCode int64
+		want            int64
+		wantErr         bool
+	}{
+		{
+			name:  "item_codes_indexed",
+			scope: ReindexScope{ItemCodes: []string{"ITEM-CODE-1"}},
+			want:  10,
+		},
+		{
+			name:            "item_codes_not_indexed",
+			scope:           ReindexScope{ItemCodes: []string{"ITEM-CODE-1"}},
+			withoutItemCode: 3,
+			wantErr:         true,
+		},
+		{
+			name:            "seller_scope_ignores_item_codes",
+			scope:           ReindexScope{SellerIDs: []int64{1}},
+			withoutItemCode: 3,
+			want:            10,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &indexator{offerRepository: countingOfferRepository{withoutItemCode: tt.withoutItemCode, total: 10}}
+			got, err := s.ScopeSize(context.Background(), tt.scope)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ScopeSize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ScopeSize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// fullPageOfferClient returns full pages of offers with ids descending from lastID.
+type fullPageOfferClient struct {
+	offer_service.OfferServiceClient
+	lastID int64
+}
+
+func (c fullPageOfferClient) SearchOffers(_ context.Context, in *offer_service.SearchOffersRequest, _ ...grpc.CallOption) (*offer_service.SearchOffersResponse, error) {
+	offers := make([]*offer_service.Offer, *in.Pagination.Limit)
+	for i := range offers {
+		offers[i] = &offer_service.Offer{Id: c.lastID - int64(*in.Pagination.Offset) - int64(i)}
+	}
+	return &offer_service.SearchOffersResponse{Offer: offers}, nil
+}
+
+func Test_forEachOfferPage_failedPages(t *testing.T) {
+	s := &indexator{offerClient: fullPageOfferClient{lastID: 100}, perPage: 2, retryPolicy: RetryPolicy{Attempts: 1, MaxFailedPages: 3}}
+	pages := 0
+	failedPages, err := s.forEachOfferPage(context.Background(), 0, func(page int, _ []*offer_service.Offer) error {
+		pages++
+		if page == 2 {
+			return nil
+		}
+		return errPageFailed
+	})
+	if !errors.Is(err, errPageFailed) {
+		t.Fatalf("forEachOfferPage() error = %v, want %v", err, errPageFailed)
+	}
+	if pages != 5 || failedPages != 4 {
+		t.Errorf("forEachOfferPage() pages = %v, failed pages = %v, want 5, 4", pages, failedPages)
+	}
+}
+
+func Test_forEachOfferPage_afterID(t *testing.T) {
+	s := &indexator{offerClient: fullPageOfferClient{lastID: 10}, perPage: 2, retryPolicy: RetryPolicy{Attempts: 1, MaxFailedPages: 3}}
+	var ids []int64
+	_, err := s.forEachOfferPage(context.Background(), 5, func(_ int, offers []*offer_service.Offer) error {
+		for _, offer := range offers {
+			ids = append(ids, offer.Id)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("forEachOfferPage() error = %v", err)
+	}
+	if want := []int64{10, 9, 8, 7, 6}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("forEachOfferPage() ids = %v, want %v", ids, want)
+	}
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+	"offer-read-service/internal/model"
+	"offer-read-service/internal/repository"
+)
+
+const IndexingLeaseName = "indexing"
+
+type Locker interface {
+	// Lock returns a context that is cancelled when the lock is lost and a function releasing the lock.
+	Lock(ctx context.Context) (context.Context, func(), error)
+	Holder(ctx context.Context) (*model.Lease, error)
+}
+
+type leaseLocker struct {
+	leaseRepository   repository.LeaseRepository
+	name              string
+	holder            string
+	ttl               time.Duration
+	heartbeatInterval time.Duration
+}
+
+func NewLeaseLocker(leaseRepository repository.LeaseRepository, name, holder string, ttl, heartbeatInterval time.Duration) Locker {
+	return &leaseLocker{
+		leaseRepository:   leaseRepository,
+		name:              name,
+		holder:            holder,
+		ttl:               ttl,
+		heartbeatInterval: heartbeatInterval,
+	}
+}
+
+func (l *leaseLocker) Lock(ctx context.Context) (context.Context, func(), error) {
+	token, err := newLeaseToken()
+	if err != nil {
+		return nil, nil, err
+	}
+	acquired, err := l.leaseRepository.Acquire(ctx, l.name, l.holder, token, l.ttl)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't acquire lease %s %w", l.name, err)
+	}
+	if !acquired {
+		lease, err := l.leaseRepository.Get(ctx, l.name)
+		if err != nil || lease == nil {
+			return nil, nil, ErrIndexingInProgress
+		}
+		return nil, nil, fmt.Errorf("%w by %s", ErrIndexingInProgress, lease.Holder)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go l.heartbeat(ctx, token, cancel, done)
+
+	return ctx, func() {
+		close(done)
+		cancel()
+		if err := l.leaseRepository.Release(context.Background(), l.name, token); err != nil {
+			ctxzap.Extract(ctx).Error("can't release lease", zap.String("lease", l.name), zap.Error(err))
+		}
+	}, nil
+}
+
+// heartbeat prolongs the lease and cancels the work once the lease is lost or could not be renewed before it expired.
+func (l *leaseLocker) heartbeat(ctx context.Context, token string, cancel context.CancelFunc, done <-chan struct{}) {
+	logger := ctxzap.Extract(ctx)
+	ticker := time.NewTicker(l.heartbeatInterval)
+	defer ticker.Stop()
+	renewed := time.Now()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		ok, err := l.leaseRepository.Renew(ctx, l.name, token, l.ttl)
+		switch {
+		case err != nil && time.Since(renewed) < l.ttl:
+			logger.Warn("can't renew lease", zap.String("lease", l.name), zap.Error(err))
+		case err != nil:
+			logger.Error("lease expired, stopping", zap.String("lease", l.name), zap.Error(err))
+			cancel()
+			return
+		case !ok:
+			logger.Error("lease lost, stopping", zap.String("lease", l.name))
+			cancel()
+			return
+		default:
+			renewed = time.Now()
+		}
+	}
+}
+
+func (l *leaseLocker) Holder(ctx context.Context) (*model.Lease, error) {
+	lease, err := l.leaseRepository.Get(ctx, l.name)
+	if err != nil {
+		return nil, fmt.Errorf("can't get lease %s %w", l.name, err)
+	}
+	return lease, nil
+}
+
+// newLeaseToken identifies one acquisition of the lease, the holder name alone is shared by all the runs in the pod.
+func newLeaseToken() (string, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return "", fmt.Errorf("can't generate lease token %w", err)
+	}
+	return hex.EncodeToString(token), nil
+}
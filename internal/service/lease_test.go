@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"offer-read-service/internal/model"
+	"offer-read-service/internal/repository"
+)
+
+// memoryLeaseRepository follows the scripts of the elastic lease repository.
+type memoryLeaseRepository struct {
+	repository.LeaseRepository
+	mu     sync.Mutex
+	leases map[string]*memoryLease
+}
+
+type memoryLease struct {
+	holder    string
+	token     string
+	expiresAt time.Time
+}
+
+func (r *memoryLeaseRepository) Acquire(_ context.Context, name, holder, token string, ttl time.Duration) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if lease, ok := r.leases[name]; ok && !lease.expiresAt.Before(time.Now()) {
+		return false, nil
+	}
+	r.leases[name] = &memoryLease{holder: holder, token: token, expiresAt: time.Now().Add(ttl)}
+	return true, nil
+}
+
+func (r *memoryLeaseRepository) Renew(_ context.Context, name, token string, ttl time.Duration) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	lease, ok := r.leases[name]
+	if !ok || lease.token != token {
+		return false, nil
+	}
+	lease.expiresAt = time.Now().Add(ttl)
+	return true, nil
+}
+
+func (r *memoryLeaseRepository) Release(_ context.Context, name, token string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if lease, ok := r.leases[name]; ok && lease.token == token {
+		delete(r.leases, name)
+	}
+	return nil
+}
+
+func (r *memoryLeaseRepository) Get(_ context.Context, name string) (*model.Lease, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if lease, ok := r.leases[name]; ok {
+		return &model.Lease{Name: name, Holder: lease.holder, ExpiresAt: lease.expiresAt}, nil
+	}
+	return nil, nil
+}
+
+func TestLeaseLocker_Lock(t *testing.T) {
+	repo := &memoryLeaseRepository{leases: map[string]*memoryLease{}}
+	locker := NewLeaseLocker(repo, IndexingLeaseName, "pod-1", time.Minute, time.Hour)
+
+	ctx, unlock, err := locker.Lock(context.Background())
+	if err != nil {
+		t.Fatalf("Lock() error = %v", err)
+	}
+
+	// the second run in the same pod must not get the lease
+	if _, _, err = locker.Lock(context.Background()); !errors.Is(err, ErrIndexingInProgress) {
+		t.Fatalf("second Lock() error = %v, want %v", err, ErrIndexingInProgress)
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("first run is cancelled by the second Lock()")
+	}
+
+	unlock()
+	if lease, _ := repo.Get(context.Background(), IndexingLeaseName); lease != nil {
+		t.Fatalf("lease is not released: %+v", lease)
+	}
+
+	_, unlockAgain, err := locker.Lock(context.Background())
+	if err != nil {
+		t.Fatalf("Lock() after release error = %v", err)
+	}
+	defer unlockAgain()
+}
+
+func TestLeaseLocker_staleRelease(t *testing.T) {
+	repo := &memoryLeaseRepository{leases: map[string]*memoryLease{}}
+	first := NewLeaseLocker(repo, IndexingLeaseName, "pod-1", time.Minute, time.Hour)
+	_, unlockFirst, err := first.Lock(context.Background())
+	if err != nil {
+		t.Fatalf("Lock() error = %v", err)
+	}
+
+	// the lease of the first run expires and is taken over by another run of the same pod
+	repo.leases[IndexingLeaseName].expiresAt = time.Now().Add(-time.Second)
+	_, unlockSecond, err := first.Lock(context.Background())
+	if err != nil {
+		t.Fatalf("Lock() of expired lease error = %v", err)
+	}
+	defer unlockSecond()
+
+	unlockFirst()
+	if lease, _ := repo.Get(context.Background(), IndexingLeaseName); lease == nil {
+		t.Errorf("stale release deleted the lease of the second run")
+	}
+}
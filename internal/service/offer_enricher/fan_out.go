@@ -0,0 +1,69 @@
+package offer_enricher
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/samber/lo"
+)
+
+var callDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "offer_read",
+	Subsystem: "enricher",
+	Name:      "call_duration_seconds",
+	Help:      "Duration of the upstream calls made by the enricher, chunks are observed one by one.",
+	Buckets:   prometheus.ExponentialBuckets(0.005, 2, 14),
+}, []string{"call"})
+
+// parallel runs the functions concurrently and returns the first error, which cancels the others.
+func parallel(ctx context.Context, fns ...func(ctx context.Context) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	for _, fn := range fns {
+		wg.Add(1)
+		go func(fn func(ctx context.Context) error) {
+			defer wg.Done()
+			if err := fn(ctx); err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}(fn)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// chunked calls fn concurrently for the chunks of codes and returns the results in the order of the chunks.
+func chunked[T any](ctx context.Context, call string, codes []string, chunkSize int, fn func(ctx context.Context, codes []string) ([]T, error)) ([]T, error) {
+	if chunkSize <= 0 {
+		chunkSize = len(codes)
+	}
+	chunks := lo.Chunk(codes, chunkSize)
+	results := make([][]T, len(chunks))
+	fns := make([]func(ctx context.Context) error, 0, len(chunks))
+	for i, chunk := range chunks {
+		i, chunk := i, chunk
+		fns = append(fns, func(ctx context.Context) error {
+			started := time.Now()
+			defer func() {
+				callDuration.WithLabelValues(call).Observe(time.Since(started).Seconds())
+			}()
+			var err error
+			results[i], err = fn(ctx, chunk)
+			return err
+		})
+	}
+	if err := parallel(ctx, fns...); err != nil {
+		return nil, err
+	}
+	return lo.Flatten(results), nil
+}
@@ -0,0 +1,49 @@
+package offer_enricher
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_chunked(t *testing.T) {
+	codes := []string{"A", "B", "C", "D", "E"}
+	upper := func(_ context.Context, codes []string) ([]string, error) {
+		return []string{strings.Join(codes, "")}, nil
+	}
+	tests := []struct {
+		name      string
+		chunkSize int
+		fn        func(ctx context.Context, codes []string) ([]string, error)
+		want      []string
+		wantErr   bool
+	}{
+		{name: "chunks_in_order", chunkSize: 2, fn: upper, want: []string{"AB", "CD", "E"}},
+		{name: "no_bound", chunkSize: 0, fn: upper, want: []string{"ABCDE"}},
+		{
+			name:      "first_error_cancels_others",
+			chunkSize: 1,
+			fn: func(ctx context.Context, codes []string) ([]string, error) {
+				if codes[0] == "C" {
+					return nil, errors.New("unavailable")
+				}
+				<-ctx.Done()
+				return nil, ctx.Err()
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := chunked(context.Background(), "test", codes, tt.chunkSize, tt.fn)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("chunked() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("chunked() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
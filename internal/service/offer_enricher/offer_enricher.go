@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/samber/lo"
 	"gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/catalog_read_service"
 	"gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/catalog_write"
@@ -13,10 +15,42 @@ import (
 	"go.uber.org/zap"
 	"offer-read-service/internal/model"
 	"offer-read-service/internal/repository"
+	"sort"
 	"strings"
 	"time"
 )
 
+const (
+	// initial page size per requested offer, most offers have one or two stock unit versions
+	stockUnitsPerOffer = 4
+	// the versions of one offer are not requested beyond this limit
+	maxStockUnitsPerOffer = 1024
+	// more versions than this are logged as unusual
+	unusualStockUnitVersions = 10
+)
+
+var (
+	stockUnitVersions = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "offer_read",
+		Subsystem: "enricher",
+		Name:      "stock_unit_versions",
+		Help:      "Number of stock unit versions of an enriched offer.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 11),
+	})
+	degradedOffers = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "offer_read",
+		Subsystem: "enricher",
+		Name:      "degraded_offers_total",
+		Help:      "Number of offers enriched with incomplete catalog data.",
+	}, []string{"data_quality"})
+	excludedOffers = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "offer_read",
+		Subsystem: "enricher",
+		Name:      "excluded_offers_total",
+		Help:      "Number of offers excluded from the index by the status overrides.",
+	}, []string{"override"})
+)
+
 const (
 	stockReasonReleased = "released"
 	stockReasonSold     = "sold"
@@ -30,10 +64,18 @@ type enricher struct {
 	catalogWriteClient catalog_write.CatalogWriteServiceClient
 	stockClient        stock_service.StockServiceClient
 	offerRepository    repository.OfferRepository
+	// timeout bounds all the upstream calls of one Enrich, 0 means no bound
+	timeout time.Duration
+	// chunkSize bounds the number of codes in one upstream call, 0 means no bound
+	chunkSize int
+	// priceRules keep the offers with too low price in the new status
+	priceRules []PriceRule
+	// statusOverrides replace the publication based status by the item attributes
+	statusOverrides []StatusOverride
 }
 
-func NewEnricher(catalogReadClient catalog_read_service.CatalogReadSearchServiceClient, catalogWriteClient catalog_write.CatalogWriteServiceClient, stockClient stock_service.StockServiceClient, offerRepository repository.OfferRepository) *enricher {
-	return &enricher{catalogReadClient: catalogReadClient, catalogWriteClient: catalogWriteClient, stockClient: stockClient, offerRepository: offerRepository}
+func NewEnricher(catalogReadClient catalog_read_service.CatalogReadSearchServiceClient, catalogWriteClient catalog_write.CatalogWriteServiceClient, stockClient stock_service.StockServiceClient, offerRepository repository.OfferRepository, timeout time.Duration, chunkSize int, priceRules []PriceRule, statusOverrides []StatusOverride) *enricher {
+	return &enricher{catalogReadClient: catalogReadClient, catalogWriteClient: catalogWriteClient, stockClient: stockClient, offerRepository: offerRepository, timeout: timeout, chunkSize: chunkSize, priceRules: priceRules, statusOverrides: statusOverrides}
 }
 
 func (s enricher) Enrich(ctx context.Context, offers []*offer_service.Offer) ([]model.Offer, error) {
@@ -48,51 +90,87 @@ func (s enricher) Enrich(ctx context.Context, offers []*offer_service.Offer) ([]
 		return o.OfferCode
 	})
 
-	units, err := s.stockClient.ListStockUnits(ctx, &stock_service.ListStockUnitsRequest{
-		Limit:      int32(len(offerCodes) + 1),
-		OfferCodes: offerCodes,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("s.stockClient.ListStockUnits: %w", err)
+	// the calls are independent, they are made concurrently and share the deadline
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
 	}
-
-	offersFromDBSlice, err := s.offerRepository.ListOffer(ctx, v1.GetListRequest{
-		Filters: &v1.GetListRequest_FilterGroup{
-			Filters: []*v1.GetListRequest_FilterGroup_FieldFilter{
-				{
-					Field: "offer.code",
-					Filter: &v1.GetListRequest_FilterGroup_FieldFilter_FilterTextIn{
-						FilterTextIn: &v1.GetListRequest_FilterGroup_FieldFilter_FilterTypeTextIn{
-							Value: offerCodes,
-						},
-					},
-				},
-			},
+	var units []*stock_service.StockUnit
+	var offersFromDBSlice []model.Offer
+	var catalogWriteItemsSlice []*catalog_write.ItemComposite
+	err := parallel(ctx,
+		func(ctx context.Context) error {
+			var err error
+			units, err = chunked(ctx, "stock_units", offerCodes, s.chunkSize, s.listStockUnits)
+			return err
 		},
-	})
+		func(ctx context.Context) error {
+			var err error
+			offersFromDBSlice, err = chunked(ctx, "stored_offers", offerCodes, s.chunkSize, s.storedOffers)
+			return err
+		},
+		func(ctx context.Context) error {
+			var err error
+			catalogWriteItemsSlice, err = chunked(ctx, "catalog_write_items", lo.Uniq(itemCodes), s.chunkSize, s.catalogWriteItems)
+			return err
+		},
+	)
 	if err != nil {
-		return nil, fmt.Errorf("s.repo.ListOffer: %w", err)
+		return nil, err
 	}
-	offersFromDB := lo.SliceToMap(offersFromDBSlice.Data, func(item model.Offer) (string, model.Offer) {
-		return item.Code, item
-	})
 
-	wItem, err := s.catalogWriteClient.GetItemListByCodes(ctx, &catalog_write.GetItemListByCodesRequest{
-		Codes: itemCodes,
+	offerUnits := lo.GroupBy(units, func(item *stock_service.StockUnit) string {
+		return item.OfferCode
 	})
-	if err != nil {
-		return nil, fmt.Errorf("s.catalogWriteClient.GetItemListByCodes: %w", err)
+	for offerCode, versions := range offerUnits {
+		stockUnitVersions.Observe(float64(len(versions)))
+		if len(versions) > unusualStockUnitVersions {
+			logger.Warn("offer has unusual number of stock unit versions", zap.String("offer_code", offerCode), zap.Int("versions", len(versions)))
+		}
 	}
-	catalogWriteItems := lo.SliceToMap(wItem.Data, func(item *catalog_write.ItemComposite) (string, *catalog_write.ItemComposite) {
+	offersFromDB := lo.SliceToMap(offersFromDBSlice, func(item model.Offer) (string, model.Offer) {
+		return item.Code, item
+	})
+	catalogWriteItems := lo.SliceToMap(catalogWriteItemsSlice, func(item *catalog_write.ItemComposite) (string, *catalog_write.ItemComposite) {
 		return item.Item.Code, item
 	})
 
+	// items missing in catalog write are taken from catalog read, offers missing in both keep their previous status
+	catalogReadItems := s.catalogReadFallback(ctx, lo.Filter(lo.Uniq(itemCodes), func(itemCode string, _ int) bool {
+		return catalogWriteItems[itemCode] == nil
+	}))
+	for itemCode, item := range catalogReadItems {
+		catalogWriteItems[itemCode] = item
+	}
+
+	// catalog read lacks the item attributes, the status overrides of its items can't be evaluated
+	overridesUnknown := func(itemCode string) bool {
+		return len(s.statusOverrides) > 0 && catalogReadItems[itemCode] != nil
+	}
+
+	// an offer with versions up to the limit may have more of them, its status can't be calculated
+	truncated := func(offerCode string) bool {
+		return len(offerUnits[offerCode]) >= maxStockUnitsPerOffer
+	}
+
 	offers = lo.Filter(offers, func(offer *offer_service.Offer, _ int) bool {
-		ok := catalogWriteItems[offer.ItemCode] != nil
-		if !ok {
-			logger.Warn("catalog write doesn't have offer", zap.String("item_code", offer.ItemCode))
+		if offersFromDB[offer.OfferCode].Status != "" {
+			return true
+		}
+		if catalogWriteItems[offer.ItemCode] == nil {
+			logger.Warn("catalogs don't have item of not indexed offer", zap.String("item_code", offer.ItemCode), zap.String("offer_code", offer.OfferCode))
+			return false
 		}
-		return ok
+		if truncated(offer.OfferCode) {
+			logger.Error("stock unit versions of not indexed offer are truncated", zap.String("offer_code", offer.OfferCode))
+			return false
+		}
+		if overridesUnknown(offer.ItemCode) {
+			logger.Warn("catalog read has item of not indexed offer without attributes", zap.String("item_code", offer.ItemCode), zap.String("offer_code", offer.OfferCode))
+			return false
+		}
+		return true
 	})
 	if len(offers) == 0 {
 		return nil, nil
@@ -105,16 +183,50 @@ func (s enricher) Enrich(ctx context.Context, offers []*offer_service.Offer) ([]
 			ID:                              int(offer.Id),
 			Code:                            offer.OfferCode,
 			SellerID:                        int(offer.SellerId),
+			ItemCode:                        offer.ItemCode,
 			IsNewCalculateDate:              offerFromDB.IsNewCalculateDate,
 			IsSalesCalculateDate:            offerFromDB.IsSalesCalculateDate,
 			IsOrderCalculateDate:            offerFromDB.IsOrderCalculateDate,
 			IsSoldCalculateDate:             offerFromDB.IsSoldCalculateDate,
 			IsReturnedToSellerCalculateDate: offerFromDB.IsReturnedToSellerCalculateDate,
 			Indexed:                         time.Now(),
+			PriceRule:                       s.failedPriceRule(offer),
+		}
+
+		switch {
+		case catalogWriteItems[offer.ItemCode] == nil:
+			res.Status = offerFromDB.Status
+			res.DataQuality = model.DataQualityCatalogMissing
+			logger.Warn("catalogs don't have item, offer keeps previous status", zap.String("item_code", offer.ItemCode), zap.String("offer_code", offer.OfferCode))
+			degradedOffers.WithLabelValues(string(res.DataQuality)).Inc()
+			return res
+		case overridesUnknown(offer.ItemCode):
+			// the stored status already has the overrides applied, excluded offers aren't stored
+			res.Status = offerFromDB.Status
+			res.DataQuality = model.DataQualityCatalogRead
+			degradedOffers.WithLabelValues(string(res.DataQuality)).Inc()
+			return res
+		case catalogReadItems[offer.ItemCode] != nil:
+			res.DataQuality = model.DataQualityCatalogRead
+			degradedOffers.WithLabelValues(string(res.DataQuality)).Inc()
+		}
+
+		if override, ok := s.statusOverride(catalogWriteItems[offer.ItemCode]); ok && override.Action == StatusOverrideExclude {
+			res.Excluded = true
+			excludedOffers.WithLabelValues(override.Name).Inc()
+			return res
+		}
+
+		if truncated(offer.OfferCode) {
+			res.Status = offerFromDB.Status
+			res.DataQuality = model.DataQualityStockTruncated
+			logger.Error("stock unit versions are truncated, offer keeps previous status", zap.String("offer_code", offer.OfferCode))
+			degradedOffers.WithLabelValues(string(res.DataQuality)).Inc()
+			return res
 		}
 
 		var date time.Time
-		res.Status, date = s.calculateStatus(offer, catalogWriteItems, units.StockUnits)
+		res.Status, date = s.calculateStatus(offer, catalogWriteItems, offerUnits[offer.OfferCode])
 
 		switch {
 		case res.Status == model.OfferStatusCodeNew:
@@ -133,79 +245,208 @@ func (s enricher) Enrich(ctx context.Context, offers []*offer_service.Offer) ([]
 	}), nil
 }
 
-func (s enricher) calculateStatus(
-	offer *offer_service.Offer,
-	catalogWriteOffers map[string]*catalog_write.ItemComposite,
-	units []*stock_service.StockUnit,
-) (model.OfferStatusCode, time.Time) {
-	units = lo.Filter(units, func(item *stock_service.StockUnit, _ int) bool {
-		return !strings.Contains(item.VersionClosingReason, "duplicate")
+func (s enricher) storedOffers(ctx context.Context, offerCodes []string) ([]model.Offer, error) {
+	offers, err := s.offerRepository.ListOffer(ctx, v1.GetListRequest{
+		Filters: &v1.GetListRequest_FilterGroup{
+			Filters: []*v1.GetListRequest_FilterGroup_FieldFilter{
+				{
+					Field: "offer.code",
+					Filter: &v1.GetListRequest_FilterGroup_FieldFilter_FilterTextIn{
+						FilterTextIn: &v1.GetListRequest_FilterGroup_FieldFilter_FilterTypeTextIn{
+							Value: offerCodes,
+						},
+					},
+				},
+			},
+		},
 	})
+	if err != nil {
+		return nil, fmt.Errorf("s.repo.ListOffer: %w", err)
+	}
+	return offers.Data, nil
+}
 
-	for _, unit := range units {
-		if unit.OfferCode != offer.OfferCode {
-			continue
+func (s enricher) catalogWriteItems(ctx context.Context, itemCodes []string) ([]*catalog_write.ItemComposite, error) {
+	items, err := s.catalogWriteClient.GetItemListByCodes(ctx, &catalog_write.GetItemListByCodesRequest{
+		Codes: itemCodes,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("s.catalogWriteClient.GetItemListByCodes: %w", err)
+	}
+	return items.Data, nil
+}
+
+// catalogReadFallback returns the items found in catalog read converted to catalog write items, they carry
+// the publication and creation data only. The fallback is best effort, a failed request leaves the items missing.
+func (s enricher) catalogReadFallback(ctx context.Context, itemCodes []string) map[string]*catalog_write.ItemComposite {
+	if len(itemCodes) == 0 || s.catalogReadClient == nil {
+		return nil
+	}
+	items, err := chunked(ctx, "catalog_read_items", itemCodes, s.chunkSize, s.catalogReadItems)
+	if err != nil {
+		ctxzap.Extract(ctx).Warn("can't fall back to catalog read", zap.Strings("item_codes", itemCodes), zap.Error(err))
+		return nil
+	}
+	return lo.SliceToMap(items, func(item *catalog_read_service.ItemComposite) (string, *catalog_write.ItemComposite) {
+		return item.Item.Code, &catalog_write.ItemComposite{
+			Item: &catalog_write.Item{
+				Code: item.Item.Code,
+				// both catalogs share the publication flags enumeration
+				PublicationFlags: lo.Map(item.Item.PublicationFlags, func(flag catalog_read_service.ItemPublicationFlag, _ int) catalog_write.ItemPublicationFlag {
+					return catalog_write.ItemPublicationFlag(flag)
+				}),
+				IsDraft:   item.Item.IsDraft,
+				CreatedAt: item.Item.CreatedAt,
+			},
 		}
+	})
+}
 
-		if unit.IsAvailableForPurchase {
-			item, ok := catalogWriteOffers[offer.ItemCode]
-			if ok {
-				switch {
-				case !lo.Contains(item.Item.PublicationFlags, catalog_write.ItemPublicationFlag_ITEM_PUBLICATION_FLAG_VISIBLE_IOS):
-					if s.isNotExhibitedIronWatch(item.Attributes, offer) {
-						return model.OfferStatusCodeSales, catalogWriteOffers[offer.ItemCode].Item.CreatedAt.AsTime()
-					}
+func (s enricher) catalogReadItems(ctx context.Context, itemCodes []string) ([]*catalog_read_service.ItemComposite, error) {
+	items, err := s.catalogReadClient.GetItemListByCodes(ctx, &catalog_read_service.GetItemListByCodesRequest{
+		Codes: itemCodes,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("s.catalogReadClient.GetItemListByCodes: %w", err)
+	}
+	return items.Data, nil
+}
 
-					return model.OfferStatusCodeNew, catalogWriteOffers[offer.ItemCode].Item.CreatedAt.AsTime()
-				case item.Item.IsDraft:
-					return model.OfferStatusCodeNew, catalogWriteOffers[offer.ItemCode].Item.CreatedAt.AsTime()
-				}
-			}
+// listStockUnits returns all the stock unit versions of the offers. ListStockUnits is bounded by a limit only,
+// so a full page is treated as truncated: the offers are split in halves and requested again, the page of a single
+// offer grows up to maxStockUnitsPerOffer. An offer reaching it is returned with the versions of the full page,
+// Enrich keeps its previous status.
+func (s enricher) listStockUnits(ctx context.Context, offerCodes []string) ([]*stock_service.StockUnit, error) {
+	limit := len(offerCodes) * stockUnitsPerOffer
+	for {
+		units, err := s.stockClient.ListStockUnits(ctx, &stock_service.ListStockUnitsRequest{
+			Limit:      int32(limit),
+			OfferCodes: offerCodes,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("s.stockClient.ListStockUnits: %w", err)
+		}
+		if len(units.StockUnits) < limit {
+			return units.StockUnits, nil
+		}
 
-			if offer.Price == nil || (offer.Price.CurrencyCode == "RUB" && offer.Price.Units < 1000) {
-				return model.OfferStatusCodeNew, catalogWriteOffers[offer.ItemCode].Item.CreatedAt.AsTime()
-			} else {
-				return model.OfferStatusCodeSales, catalogWriteOffers[offer.ItemCode].Item.CreatedAt.AsTime()
+		if len(offerCodes) > 1 {
+			var all []*stock_service.StockUnit
+			for _, half := range [][]string{offerCodes[:len(offerCodes)/2], offerCodes[len(offerCodes)/2:]} {
+				halfUnits, err := s.listStockUnits(ctx, half)
+				if err != nil {
+					return nil, err
+				}
+				all = append(all, halfUnits...)
 			}
+			return all, nil
 		}
-
-		if unit.VersionClosingReason == stockReasonSold {
-			return model.OfferStatusCodeSold, unit.VersionClosedAt.AsTime()
+		if limit >= maxStockUnitsPerOffer {
+			return units.StockUnits, nil
 		}
+		limit *= 2
+	}
+}
 
-		if unit.IsReserved {
-			return model.OfferStatusCodeInOrder, unit.ReservedAt.AsTime()
-		}
+// calculateStatus takes the status from the current version of the offer's stock unit, the earlier versions
+// only move the status date back while they are in the same status. Versions in no known state are skipped.
+func (s enricher) calculateStatus(
+	offer *offer_service.Offer,
+	catalogWriteOffers map[string]*catalog_write.ItemComposite,
+	units []*stock_service.StockUnit,
+) (model.OfferStatusCode, time.Time) {
+	versions := sortedVersions(lo.Filter(units, func(item *stock_service.StockUnit, _ int) bool {
+		return item.OfferCode == offer.OfferCode && !strings.Contains(item.VersionClosingReason, "duplicate")
+	}))
 
-		if unit.VersionClosingReason == stockReasonReleased {
-			return model.OfferStatusCodeInOrder, unit.VersionClosedAt.AsTime()
+	var status model.OfferStatusCode
+	var date time.Time
+	for _, version := range versions {
+		versionStatus, versionDate, ok := s.versionStatus(offer, catalogWriteOffers, version)
+		switch {
+		case !ok:
+			continue
+		case status == "":
+			status, date = versionStatus, versionDate
+			continue
+		case versionStatus != status:
+			return status, date
+		case versionDate.Before(date):
+			date = versionDate
 		}
+	}
+	if status == "" {
+		return model.OfferStatusCodeNew, itemCreatedAt(catalogWriteOffers, offer.ItemCode)
+	}
+	return status, date
+}
 
-		if unit.VersionClosingReason == stockReasonReturned {
-			return model.OfferStatusCodeReturnedToSeller, unit.VersionClosedAt.AsTime()
+// sortedVersions orders the versions from the current one: the open versions go first, the closed ones
+// from the latest closed, equal versions keep the order of the stock service.
+func sortedVersions(units []*stock_service.StockUnit) []*stock_service.StockUnit {
+	sort.SliceStable(units, func(i, j int) bool {
+		iOpen, jOpen := units[i].VersionClosedAt == nil, units[j].VersionClosedAt == nil
+		if iOpen != jOpen {
+			return iOpen
 		}
-
-		if unit.VersionClosingReason == stockReasonLost {
-			return model.OfferStatusCodeSales, catalogWriteOffers[offer.ItemCode].Item.CreatedAt.AsTime()
+		if !iOpen && !units[i].VersionClosedAt.AsTime().Equal(units[j].VersionClosedAt.AsTime()) {
+			return units[i].VersionClosedAt.AsTime().After(units[j].VersionClosedAt.AsTime())
 		}
+		return units[i].ReservedAt.AsTime().After(units[j].ReservedAt.AsTime())
+	})
+	return units
+}
 
-		if unit.VersionClosingReason == stockReasonMoved {
-			return model.OfferStatusCodeNew, catalogWriteOffers[offer.ItemCode].Item.CreatedAt.AsTime()
+func (s enricher) versionStatus(
+	offer *offer_service.Offer,
+	catalogWriteOffers map[string]*catalog_write.ItemComposite,
+	unit *stock_service.StockUnit,
+) (model.OfferStatusCode, time.Time, bool) {
+	createdAt := itemCreatedAt(catalogWriteOffers, offer.ItemCode)
+	if unit.IsAvailableForPurchase {
+		item, ok := catalogWriteOffers[offer.ItemCode]
+		override, overridden := s.statusOverride(item)
+		if ok {
+			switch {
+			case !lo.Contains(item.Item.PublicationFlags, catalog_write.ItemPublicationFlag_ITEM_PUBLICATION_FLAG_VISIBLE_IOS):
+				if overridden && override.Action == StatusOverrideSales && offer.Price != nil {
+					return model.OfferStatusCodeSales, createdAt, true
+				}
+
+				return model.OfferStatusCodeNew, createdAt, true
+			case item.Item.IsDraft:
+				return model.OfferStatusCodeNew, createdAt, true
+			}
 		}
 
+		if (overridden && override.Action == StatusOverrideNew) || s.failedPriceRule(offer) != "" {
+			return model.OfferStatusCodeNew, createdAt, true
+		} else {
+			return model.OfferStatusCodeSales, createdAt, true
+		}
 	}
 
-	return model.OfferStatusCodeNew, catalogWriteOffers[offer.ItemCode].Item.CreatedAt.AsTime()
+	switch {
+	case unit.VersionClosingReason == stockReasonSold:
+		return model.OfferStatusCodeSold, unit.VersionClosedAt.AsTime(), true
+	case unit.IsReserved:
+		return model.OfferStatusCodeInOrder, unit.ReservedAt.AsTime(), true
+	case unit.VersionClosingReason == stockReasonReleased:
+		return model.OfferStatusCodeInOrder, unit.VersionClosedAt.AsTime(), true
+	case unit.VersionClosingReason == stockReasonReturned:
+		return model.OfferStatusCodeReturnedToSeller, unit.VersionClosedAt.AsTime(), true
+	case unit.VersionClosingReason == stockReasonLost:
+		return model.OfferStatusCodeSales, createdAt, true
+	case unit.VersionClosingReason == stockReasonMoved:
+		return model.OfferStatusCodeNew, createdAt, true
+	}
+	return "", time.Time{}, false
 }
 
-func (s enricher) isNotExhibitedIronWatch(attributes []*catalog_write.ItemAttributeComposite, offer *offer_service.Offer) bool {
-	for _, attribute := range attributes {
-		for _, value := range attribute.AttributeValues {
-			if value.Code == "ADDITIONAL_FEATURES_IRON_WATCHES" && offer.Price != nil {
-				return true
-			}
-		}
+func itemCreatedAt(catalogWriteOffers map[string]*catalog_write.ItemComposite, itemCode string) time.Time {
+	item, ok := catalogWriteOffers[itemCode]
+	if !ok {
+		return time.Time{}
 	}
-
-	return false
+	return item.Item.CreatedAt.AsTime()
 }
@@ -1,16 +1,20 @@
 package offer_enricher
 
 import (
+	"context"
 	"gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/catalog_read_service"
 	"gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/catalog_write"
 	"gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/common/money"
+	v1 "gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/common/search_kit/v1"
 	"gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/offer_service"
 	"gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/stock_service"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
 	"google.golang.org/protobuf/types/known/timestamppb"
 	"offer-read-service/internal/model"
 	"offer-read-service/internal/repository"
 	"reflect"
+	"sort"
 	"sync"
 	"testing"
 	"time"
@@ -131,3 +135,269 @@ func Test_calculateStatus(t *testing.T) {
 		})
 	}
 }
+
+func Test_calculateStatus_versions(t *testing.T) {
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	t1, t2, t3 := createdAt.Add(time.Hour), createdAt.Add(2*time.Hour), createdAt.Add(3*time.Hour)
+	offer := &offer_service.Offer{
+		OfferCode: "OFFER-CODE-1",
+		ItemCode:  "ITEM-CODE-1",
+		Price:     &money.Money{CurrencyCode: "RUB", Units: 17_000},
+	}
+	catalogWriteOffers := map[string]*catalog_write.ItemComposite{
+		"ITEM-CODE-1": {
+			Item: &catalog_write.Item{
+				CreatedAt:        timestamppb.New(createdAt),
+				PublicationFlags: []catalog_write.ItemPublicationFlag{catalog_write.ItemPublicationFlag_ITEM_PUBLICATION_FLAG_VISIBLE_IOS},
+			},
+		},
+	}
+	closed := func(reason string, at time.Time) *stock_service.StockUnit {
+		return &stock_service.StockUnit{OfferCode: "OFFER-CODE-1", VersionClosingReason: reason, VersionClosedAt: timestamppb.New(at)}
+	}
+
+	tests := []struct {
+		name     string
+		units    []*stock_service.StockUnit
+		want     model.OfferStatusCode
+		wantDate time.Time
+	}{
+		{
+			name:     "sold_after_returned",
+			units:    []*stock_service.StockUnit{closed(stockReasonReturned, t1), closed(stockReasonSold, t2)},
+			want:     model.OfferStatusCodeSold,
+			wantDate: t2,
+		},
+		{
+			name:     "returned_after_sold",
+			units:    []*stock_service.StockUnit{closed(stockReasonReturned, t2), closed(stockReasonSold, t1)},
+			want:     model.OfferStatusCodeReturnedToSeller,
+			wantDate: t2,
+		},
+		{
+			name: "open_version_is_current",
+			units: []*stock_service.StockUnit{
+				closed(stockReasonSold, t2),
+				{OfferCode: "OFFER-CODE-1", IsAvailableForPurchase: true},
+			},
+			want:     model.OfferStatusCodeSales,
+			wantDate: createdAt,
+		},
+		{
+			name: "reserved_again_keeps_earliest_date",
+			units: []*stock_service.StockUnit{
+				{OfferCode: "OFFER-CODE-1", IsReserved: true, ReservedAt: timestamppb.New(t3)},
+				closed(stockReasonReleased, t1),
+			},
+			want:     model.OfferStatusCodeInOrder,
+			wantDate: t1,
+		},
+		{
+			name:     "earlier_status_does_not_change_date",
+			units:    []*stock_service.StockUnit{closed(stockReasonReleased, t1), closed(stockReasonSold, t2), closed(stockReasonReleased, t3)},
+			want:     model.OfferStatusCodeInOrder,
+			wantDate: t3,
+		},
+		{
+			name:     "unknown_reason_skipped",
+			units:    []*stock_service.StockUnit{closed("unknown", t3), closed(stockReasonSold, t2)},
+			want:     model.OfferStatusCodeSold,
+			wantDate: t2,
+		},
+		{
+			name:     "duplicate_skipped",
+			units:    []*stock_service.StockUnit{closed("sold-duplicate", t3), closed(stockReasonReturned, t2)},
+			want:     model.OfferStatusCodeReturnedToSeller,
+			wantDate: t2,
+		},
+		{
+			name:     "no_versions",
+			want:     model.OfferStatusCodeNew,
+			wantDate: createdAt,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, gotDate := enricher{}.calculateStatus(offer, catalogWriteOffers, tt.units)
+			if got != tt.want {
+				t.Errorf("calculateStatus() status = %v, want %v", got, tt.want)
+			}
+			if !gotDate.Equal(tt.wantDate) {
+				t.Errorf("calculateStatus() date = %v, want %v", gotDate, tt.wantDate)
+			}
+		})
+	}
+}
+
+type pagedStockClient struct {
+	stock_service.StockServiceClient
+	versions map[string]int
+	calls    int
+}
+
+func (c *pagedStockClient) ListStockUnits(_ context.Context, in *stock_service.ListStockUnitsRequest, _ ...grpc.CallOption) (*stock_service.ListStockUnitsResponse, error) {
+	c.calls++
+	var units []*stock_service.StockUnit
+	for _, offerCode := range in.OfferCodes {
+		for i := 0; i < c.versions[offerCode] && len(units) < int(in.Limit); i++ {
+			units = append(units, &stock_service.StockUnit{OfferCode: offerCode})
+		}
+	}
+	return &stock_service.ListStockUnitsResponse{StockUnits: units}, nil
+}
+
+func Test_listStockUnits(t *testing.T) {
+	tests := []struct {
+		name     string
+		versions map[string]int
+		want     map[string]int
+	}{
+		{
+			name:     "one_page",
+			versions: map[string]int{"OFFER-CODE-1": 1, "OFFER-CODE-2": 2, "OFFER-CODE-3": 0},
+			want:     map[string]int{"OFFER-CODE-1": 1, "OFFER-CODE-2": 2},
+		},
+		{
+			name:     "split_offers",
+			versions: map[string]int{"OFFER-CODE-1": 1, "OFFER-CODE-2": 9, "OFFER-CODE-3": 4},
+			want:     map[string]int{"OFFER-CODE-1": 1, "OFFER-CODE-2": 9, "OFFER-CODE-3": 4},
+		},
+		{
+			name:     "many_versions",
+			versions: map[string]int{"OFFER-CODE-1": 100},
+			want:     map[string]int{"OFFER-CODE-1": 100},
+		},
+		{
+			name:     "truncated",
+			versions: map[string]int{"OFFER-CODE-1": maxStockUnitsPerOffer + 1},
+			want:     map[string]int{"OFFER-CODE-1": maxStockUnitsPerOffer},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			offerCodes := make([]string, 0, len(tt.versions))
+			for offerCode := range tt.versions {
+				offerCodes = append(offerCodes, offerCode)
+			}
+			sort.Strings(offerCodes)
+			s := &enricher{stockClient: &pagedStockClient{versions: tt.versions}}
+
+			units, err := s.listStockUnits(context.Background(), offerCodes)
+			if err != nil {
+				t.Fatalf("listStockUnits() error = %v", err)
+			}
+			got := map[string]int{}
+			for _, unit := range units {
+				got[unit.OfferCode]++
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("listStockUnits() versions = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+type storedOffersRepository struct {
+	repository.OfferRepository
+	offers []model.Offer
+}
+
+func (r storedOffersRepository) ListOffer(context.Context, v1.GetListRequest) (*repository.ListResponse[model.Offer], error) {
+	return &repository.ListResponse[model.Offer]{Data: r.offers}, nil
+}
+
+type catalogWriteClient struct {
+	catalog_write.CatalogWriteServiceClient
+	items []*catalog_write.ItemComposite
+}
+
+func (c catalogWriteClient) GetItemListByCodes(context.Context, *catalog_write.GetItemListByCodesRequest, ...grpc.CallOption) (*catalog_write.GetItemListByCodesResponse, error) {
+	return &catalog_write.GetItemListByCodesResponse{Data: c.items}, nil
+}
+
+type catalogReadClient struct {
+	catalog_read_service.CatalogReadSearchServiceClient
+	items []*catalog_read_service.ItemComposite
+}
+
+func (c catalogReadClient) GetItemListByCodes(context.Context, *catalog_read_service.GetItemListByCodesRequest, ...grpc.CallOption) (*catalog_read_service.GetItemListByCodesResponse, error) {
+	return &catalog_read_service.GetItemListByCodesResponse{Data: c.items}, nil
+}
+
+func Test_Enrich_catalogFallback(t *testing.T) {
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	soldAt := createdAt.Add(time.Hour)
+	s := &enricher{
+		stockClient: &pagedStockClient{versions: map[string]int{}},
+		offerRepository: storedOffersRepository{offers: []model.Offer{
+			{Code: "OFFER-CODE-3", Status: model.OfferStatusCodeSold, IsSoldCalculateDate: soldAt},
+		}},
+		catalogWriteClient: catalogWriteClient{items: []*catalog_write.ItemComposite{
+			{Item: &catalog_write.Item{Code: "ITEM-CODE-1", CreatedAt: timestamppb.New(createdAt)}},
+		}},
+		catalogReadClient: catalogReadClient{items: []*catalog_read_service.ItemComposite{
+			{Item: &catalog_read_service.Item{Code: "ITEM-CODE-2", CreatedAt: timestamppb.New(createdAt)}},
+		}},
+	}
+	offers := []*offer_service.Offer{
+		{OfferCode: "OFFER-CODE-1", ItemCode: "ITEM-CODE-1"},
+		{OfferCode: "OFFER-CODE-2", ItemCode: "ITEM-CODE-2"},
+		{OfferCode: "OFFER-CODE-3", ItemCode: "ITEM-CODE-3"},
+		{OfferCode: "OFFER-CODE-4", ItemCode: "ITEM-CODE-4"},
+	}
+
+	got, err := s.Enrich(context.Background(), offers)
+	if err != nil {
+		t.Fatalf("Enrich() error = %v", err)
+	}
+	type quality struct {
+		status      model.OfferStatusCode
+		date        time.Time
+		dataQuality model.DataQuality
+	}
+	gotQuality := map[string]quality{}
+	for _, offer := range got {
+		gotQuality[offer.Code] = quality{status: offer.Status, date: offer.GetStatusDate(), dataQuality: offer.DataQuality}
+	}
+	want := map[string]quality{
+		"OFFER-CODE-1": {status: model.OfferStatusCodeNew, date: createdAt},
+		"OFFER-CODE-2": {status: model.OfferStatusCodeNew, date: createdAt, dataQuality: model.DataQualityCatalogRead},
+		"OFFER-CODE-3": {status: model.OfferStatusCodeSold, date: soldAt, dataQuality: model.DataQualityCatalogMissing},
+	}
+	if !reflect.DeepEqual(gotQuality, want) {
+		t.Errorf("Enrich() = %v, want %v", gotQuality, want)
+	}
+}
+
+func Test_Enrich_stockTruncated(t *testing.T) {
+	soldAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := &enricher{
+		stockClient: &pagedStockClient{versions: map[string]int{
+			"OFFER-CODE-1": maxStockUnitsPerOffer + 1,
+			"OFFER-CODE-2": maxStockUnitsPerOffer + 1,
+		}},
+		offerRepository: storedOffersRepository{offers: []model.Offer{
+			{Code: "OFFER-CODE-1", Status: model.OfferStatusCodeSold, IsSoldCalculateDate: soldAt},
+		}},
+		catalogWriteClient: catalogWriteClient{items: []*catalog_write.ItemComposite{
+			{Item: &catalog_write.Item{Code: "ITEM-CODE-1", CreatedAt: timestamppb.New(soldAt)}},
+		}},
+	}
+	offers := []*offer_service.Offer{
+		{OfferCode: "OFFER-CODE-1", ItemCode: "ITEM-CODE-1"},
+		{OfferCode: "OFFER-CODE-2", ItemCode: "ITEM-CODE-1"},
+	}
+
+	got, err := s.Enrich(context.Background(), offers)
+	if err != nil {
+		t.Fatalf("Enrich() error = %v", err)
+	}
+	// the indexed offer keeps its status, the status of the new one can't be calculated
+	if len(got) != 1 || got[0].Code != "OFFER-CODE-1" {
+		t.Fatalf("Enrich() = %+v, want only OFFER-CODE-1", got)
+	}
+	if got[0].Status != model.OfferStatusCodeSold || !got[0].GetStatusDate().Equal(soldAt) || got[0].DataQuality != model.DataQualityStockTruncated {
+		t.Errorf("Enrich() = %v %v %v, want %v %v %v", got[0].Status, got[0].GetStatusDate(), got[0].DataQuality,
+			model.OfferStatusCodeSold, soldAt, model.DataQualityStockTruncated)
+	}
+}
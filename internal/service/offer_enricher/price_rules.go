@@ -0,0 +1,38 @@
+package offer_enricher
+
+import (
+	"github.com/samber/lo"
+	"gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/offer_service"
+)
+
+// PriceRuleMissingPrice is failed by the offers without price, they can't be published under any rule.
+const PriceRuleMissingPrice = "missing_price"
+
+// PriceRule is the minimum publishable price of the offers in the currency. A rule with sellers applies
+// to their offers only and takes precedence over the rules without sellers.
+type PriceRule struct {
+	Name      string `json:"name"`
+	Currency  string `json:"currency"`
+	MinUnits  int64  `json:"min_units"`
+	SellerIDs []int  `json:"seller_ids,omitempty"`
+}
+
+// failedPriceRule returns the name of the rule the offer price fails, empty if the price may be published.
+// Offers in currencies without rules pass.
+func (s enricher) failedPriceRule(offer *offer_service.Offer) string {
+	if offer.Price == nil {
+		return PriceRuleMissingPrice
+	}
+	rule, ok := lo.Find(s.priceRules, func(rule PriceRule) bool {
+		return rule.Currency == offer.Price.CurrencyCode && lo.Contains(rule.SellerIDs, int(offer.SellerId))
+	})
+	if !ok {
+		rule, ok = lo.Find(s.priceRules, func(rule PriceRule) bool {
+			return rule.Currency == offer.Price.CurrencyCode && len(rule.SellerIDs) == 0
+		})
+	}
+	if !ok || offer.Price.Units >= rule.MinUnits {
+		return ""
+	}
+	return rule.Name
+}
@@ -0,0 +1,36 @@
+package offer_enricher
+
+import (
+	"testing"
+
+	"gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/common/money"
+	"gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/offer_service"
+)
+
+func Test_failedPriceRule(t *testing.T) {
+	s := enricher{priceRules: []PriceRule{
+		{Name: "rub_minimum", Currency: "RUB", MinUnits: 1000},
+		{Name: "usd_minimum", Currency: "USD", MinUnits: 10},
+		{Name: "rub_outlet_seller", Currency: "RUB", MinUnits: 100, SellerIDs: []int{7}},
+	}}
+	tests := []struct {
+		name  string
+		offer *offer_service.Offer
+		want  string
+	}{
+		{name: "missing_price", offer: &offer_service.Offer{}, want: PriceRuleMissingPrice},
+		{name: "below_minimum", offer: &offer_service.Offer{Price: &money.Money{CurrencyCode: "RUB", Units: 999}}, want: "rub_minimum"},
+		{name: "at_minimum", offer: &offer_service.Offer{Price: &money.Money{CurrencyCode: "RUB", Units: 1000}}, want: ""},
+		{name: "other_currency", offer: &offer_service.Offer{Price: &money.Money{CurrencyCode: "USD", Units: 9}}, want: "usd_minimum"},
+		{name: "currency_without_rule", offer: &offer_service.Offer{Price: &money.Money{CurrencyCode: "EUR", Units: 1}}, want: ""},
+		{name: "seller_rule", offer: &offer_service.Offer{SellerId: 7, Price: &money.Money{CurrencyCode: "RUB", Units: 500}}, want: ""},
+		{name: "below_seller_rule", offer: &offer_service.Offer{SellerId: 7, Price: &money.Money{CurrencyCode: "RUB", Units: 50}}, want: "rub_outlet_seller"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.failedPriceRule(tt.offer); got != tt.want {
+				t.Errorf("failedPriceRule() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
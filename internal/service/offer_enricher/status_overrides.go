@@ -0,0 +1,47 @@
+package offer_enricher
+
+import (
+	"github.com/samber/lo"
+	"gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/catalog_write"
+)
+
+type StatusOverrideAction string
+
+const (
+	// StatusOverrideSales puts the offers of unpublished items on sale if they have a price.
+	StatusOverrideSales StatusOverrideAction = "sales"
+	// StatusOverrideNew keeps the offers of published items in the new status.
+	StatusOverrideNew StatusOverrideAction = "new"
+	// StatusOverrideExclude removes the offers from the index.
+	StatusOverrideExclude StatusOverrideAction = "exclude"
+)
+
+// StatusOverride replaces the publication based status of the offers whose item has the attribute,
+// with the attribute value if it is set.
+type StatusOverride struct {
+	Name           string               `json:"name"`
+	Attribute      string               `json:"attribute"`
+	AttributeValue string               `json:"attribute_value,omitempty"`
+	Action         StatusOverrideAction `json:"action"`
+}
+
+// statusOverride returns the first override matching the attributes of the item.
+func (s enricher) statusOverride(item *catalog_write.ItemComposite) (StatusOverride, bool) {
+	if item == nil {
+		return StatusOverride{}, false
+	}
+	return lo.Find(s.statusOverrides, func(override StatusOverride) bool {
+		return lo.SomeBy(item.Attributes, func(attribute *catalog_write.ItemAttributeComposite) bool {
+			return override.matches(attribute)
+		})
+	})
+}
+
+func (o StatusOverride) matches(attribute *catalog_write.ItemAttributeComposite) bool {
+	if attribute.Attribute == nil || attribute.Attribute.Code != o.Attribute {
+		return false
+	}
+	return o.AttributeValue == "" || lo.SomeBy(attribute.AttributeValues, func(value *catalog_write.AttributeValue) bool {
+		return value.Code == o.AttributeValue
+	})
+}
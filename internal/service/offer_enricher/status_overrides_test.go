@@ -0,0 +1,126 @@
+package offer_enricher
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/catalog_read_service"
+	"gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/catalog_write"
+	"gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/common/money"
+	"gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/offer_service"
+	"gitlab.int.tsum.com/preowned/libraries/go-gen-proto.git/v3/gen/utp/stock_service"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"offer-read-service/internal/model"
+)
+
+func Test_calculateStatus_overrides(t *testing.T) {
+	s := enricher{
+		priceRules: []PriceRule{{Name: "rub_minimum", Currency: "RUB", MinUnits: 1000}},
+		statusOverrides: []StatusOverride{
+			{Name: "iron_watches", Attribute: "ADDITIONAL_FEATURES", AttributeValue: "ADDITIONAL_FEATURES_IRON_WATCHES", Action: StatusOverrideSales},
+			{Name: "showroom_only", Attribute: "SHOWROOM_ONLY", Action: StatusOverrideNew},
+		},
+	}
+	item := func(published bool, attribute, attributeValue string) map[string]*catalog_write.ItemComposite {
+		composite := &catalog_write.ItemComposite{Item: &catalog_write.Item{CreatedAt: timestamppb.New(time.Now())}}
+		if published {
+			composite.Item.PublicationFlags = []catalog_write.ItemPublicationFlag{catalog_write.ItemPublicationFlag_ITEM_PUBLICATION_FLAG_VISIBLE_IOS}
+		}
+		if attribute != "" {
+			composite.Attributes = []*catalog_write.ItemAttributeComposite{{
+				Attribute:       &catalog_write.Attribute{Code: attribute},
+				AttributeValues: []*catalog_write.AttributeValue{{Code: attributeValue}},
+			}}
+		}
+		return map[string]*catalog_write.ItemComposite{"ITEM-CODE-1": composite}
+	}
+	price := &money.Money{CurrencyCode: "RUB", Units: 17_000}
+	tests := []struct {
+		name  string
+		price *money.Money
+		items map[string]*catalog_write.ItemComposite
+		want  model.OfferStatusCode
+	}{
+		{name: "unpublished", price: price, items: item(false, "", ""), want: model.OfferStatusCodeNew},
+		{name: "unpublished_forced_sales", price: price, items: item(false, "ADDITIONAL_FEATURES", "ADDITIONAL_FEATURES_IRON_WATCHES"), want: model.OfferStatusCodeSales},
+		{name: "unpublished_other_value", price: price, items: item(false, "ADDITIONAL_FEATURES", "ADDITIONAL_FEATURES_BOX"), want: model.OfferStatusCodeNew},
+		{name: "unpublished_value_of_other_attribute", price: price, items: item(false, "MATERIAL", "ADDITIONAL_FEATURES_IRON_WATCHES"), want: model.OfferStatusCodeNew},
+		{name: "forced_sales_without_price", items: item(false, "ADDITIONAL_FEATURES", "ADDITIONAL_FEATURES_IRON_WATCHES"), want: model.OfferStatusCodeNew},
+		{name: "published", price: price, items: item(true, "", ""), want: model.OfferStatusCodeSales},
+		{name: "published_forced_new", price: price, items: item(true, "SHOWROOM_ONLY", "SHOWROOM_ONLY_YES"), want: model.OfferStatusCodeNew},
+		{name: "published_forced_new_without_value", price: price, items: item(true, "SHOWROOM_ONLY", ""), want: model.OfferStatusCodeNew},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			offer := &offer_service.Offer{OfferCode: "OFFER-CODE-1", ItemCode: "ITEM-CODE-1", Price: tt.price}
+			units := []*stock_service.StockUnit{{OfferCode: "OFFER-CODE-1", IsAvailableForPurchase: true}}
+			if got, _ := s.calculateStatus(offer, tt.items, units); got != tt.want {
+				t.Errorf("calculateStatus() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_Enrich_excluded(t *testing.T) {
+	s := &enricher{
+		stockClient:     &pagedStockClient{versions: map[string]int{}},
+		offerRepository: storedOffersRepository{},
+		catalogWriteClient: catalogWriteClient{items: []*catalog_write.ItemComposite{
+			{Item: &catalog_write.Item{Code: "ITEM-CODE-1"}},
+			{
+				Item: &catalog_write.Item{Code: "ITEM-CODE-2"},
+				Attributes: []*catalog_write.ItemAttributeComposite{{
+					Attribute:       &catalog_write.Attribute{Code: "USAGE"},
+					AttributeValues: []*catalog_write.AttributeValue{{Code: "INTERNAL_USE"}},
+				}},
+			},
+		}},
+		statusOverrides: []StatusOverride{{Name: "internal_use", Attribute: "USAGE", AttributeValue: "INTERNAL_USE", Action: StatusOverrideExclude}},
+	}
+	got, err := s.Enrich(context.Background(), []*offer_service.Offer{
+		{OfferCode: "OFFER-CODE-1", ItemCode: "ITEM-CODE-1"},
+		{OfferCode: "OFFER-CODE-2", ItemCode: "ITEM-CODE-2"},
+	})
+	if err != nil {
+		t.Fatalf("Enrich() error = %v", err)
+	}
+	excluded := map[string]bool{}
+	for _, offer := range got {
+		excluded[offer.Code] = offer.Excluded
+	}
+	if len(excluded) != 2 || excluded["OFFER-CODE-1"] || !excluded["OFFER-CODE-2"] {
+		t.Errorf("Enrich() excluded = %v, want only OFFER-CODE-2", excluded)
+	}
+}
+
+func Test_Enrich_catalogFallbackOverrides(t *testing.T) {
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := &enricher{
+		stockClient: &pagedStockClient{versions: map[string]int{}},
+		offerRepository: storedOffersRepository{offers: []model.Offer{
+			{Code: "OFFER-CODE-1", Status: model.OfferStatusCodeSales, IsSalesCalculateDate: createdAt},
+		}},
+		catalogWriteClient: catalogWriteClient{},
+		catalogReadClient: catalogReadClient{items: []*catalog_read_service.ItemComposite{
+			{Item: &catalog_read_service.Item{Code: "ITEM-CODE-1", CreatedAt: timestamppb.New(createdAt)}},
+		}},
+		statusOverrides: []StatusOverride{{Name: "internal_use", Attribute: "USAGE", AttributeValue: "INTERNAL_USE", Action: StatusOverrideExclude}},
+	}
+	// OFFER-CODE-2 isn't indexed, it may be excluded by the override
+	got, err := s.Enrich(context.Background(), []*offer_service.Offer{
+		{OfferCode: "OFFER-CODE-1", ItemCode: "ITEM-CODE-1"},
+		{OfferCode: "OFFER-CODE-2", ItemCode: "ITEM-CODE-1"},
+	})
+	if err != nil {
+		t.Fatalf("Enrich() error = %v", err)
+	}
+	if len(got) != 1 || got[0].Code != "OFFER-CODE-1" {
+		t.Fatalf("Enrich() = %+v, want only OFFER-CODE-1", got)
+	}
+	if got[0].Status != model.OfferStatusCodeSales || got[0].Excluded || got[0].DataQuality != model.DataQualityCatalogRead {
+		t.Errorf("Enrich() = %v %v %v, want %v false %v", got[0].Status, got[0].Excluded, got[0].DataQuality,
+			model.OfferStatusCodeSales, model.DataQualityCatalogRead)
+	}
+}
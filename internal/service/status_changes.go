@@ -0,0 +1,65 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/samber/lo"
+	"offer-read-service/internal/model"
+	"offer-read-service/internal/repository"
+)
+
+type statusChangeRecorder struct {
+	repository.OfferRepository
+	outboxRepository repository.OutboxRepository
+}
+
+// NewStatusChangeRecorder wraps the offer repository of the write path, so that every update compares the computed
+// statuses with the stored ones and records the changes to the outbox. The changes are recorded before the offers
+// are written: if the process stops in between, the next update finds the same change and records it again
+// under the same id, so a change is never lost but may be published twice. The relay publishes a change only
+// once the index holds its new status, the changes of failed writes are not published.
+func NewStatusChangeRecorder(offerRepository repository.OfferRepository, outboxRepository repository.OutboxRepository) repository.OfferRepository {
+	return &statusChangeRecorder{OfferRepository: offerRepository, outboxRepository: outboxRepository}
+}
+
+func (r *statusChangeRecorder) Update(ctx context.Context, offers []model.Offer) error {
+	if len(offers) == 0 {
+		return nil
+	}
+	stored, err := r.OfferRepository.GetOffers(ctx, lo.Map(offers, func(item model.Offer, _ int) string {
+		return item.Code
+	}))
+	if err != nil {
+		return fmt.Errorf("offerRepository.GetOffers %w", err)
+	}
+	if err = r.outboxRepository.Add(ctx, statusChanges(stored, offers, time.Now())); err != nil {
+		return fmt.Errorf("outboxRepository.Add %w", err)
+	}
+	return r.OfferRepository.Update(ctx, offers)
+}
+
+// statusChanges skips offers which are not indexed yet, the first indexing is not a change.
+func statusChanges(stored, updated []model.Offer, now time.Time) []model.OfferStatusChanged {
+	storedByCode := lo.SliceToMap(stored, func(item model.Offer) (string, model.Offer) {
+		return item.Code, item
+	})
+	return lo.FilterMap(updated, func(offer model.Offer, _ int) (model.OfferStatusChanged, bool) {
+		old, ok := storedByCode[offer.Code]
+		if !ok || offer.Excluded || old.Status == offer.Status {
+			return model.OfferStatusChanged{}, false
+		}
+		return model.OfferStatusChanged{
+			ID:            fmt.Sprintf("%s:%s:%s:%d", offer.Code, old.Status, offer.Status, offer.GetStatusDate().UnixMilli()),
+			OfferCode:     offer.Code,
+			SellerID:      offer.SellerID,
+			ItemCode:      offer.ItemCode,
+			OldStatus:     old.Status,
+			NewStatus:     offer.Status,
+			OldStatusDate: old.GetStatusDate(),
+			NewStatusDate: offer.GetStatusDate(),
+			ChangedAt:     now,
+		}, true
+	})
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"offer-read-service/internal/model"
+	"offer-read-service/internal/repository"
+)
+
+func Test_statusChanges(t *testing.T) {
+	now := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	soldAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		stored  []model.Offer
+		updated []model.Offer
+		want    []model.OfferStatusChanged
+	}{
+		{
+			name:    "status changed",
+			stored:  []model.Offer{{Code: "A", Status: model.OfferStatusCodeInOrder}},
+			updated: []model.Offer{{Code: "A", SellerID: 1, ItemCode: "I", Status: model.OfferStatusCodeSold, IsSoldCalculateDate: soldAt}},
+			want: []model.OfferStatusChanged{{
+				ID:            "A:in_order:sold:1704067200000",
+				OfferCode:     "A",
+				SellerID:      1,
+				ItemCode:      "I",
+				OldStatus:     model.OfferStatusCodeInOrder,
+				NewStatus:     model.OfferStatusCodeSold,
+				NewStatusDate: soldAt,
+				ChangedAt:     now,
+			}},
+		},
+		{
+			name:    "status not changed",
+			stored:  []model.Offer{{Code: "A", Status: model.OfferStatusCodeSales}},
+			updated: []model.Offer{{Code: "A", Status: model.OfferStatusCodeSales}},
+		},
+		{
+			name:    "excluded from index",
+			stored:  []model.Offer{{Code: "A", Status: model.OfferStatusCodeSales}},
+			updated: []model.Offer{{Code: "A", Excluded: true}},
+		},
+		{
+			name:    "not indexed yet",
+			updated: []model.Offer{{Code: "A", Status: model.OfferStatusCodeSales}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := statusChanges(tt.stored, tt.updated, now)
+			if len(got) == 0 && len(tt.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("statusChanges() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+type memoryOutboxRepository struct {
+	repository.OutboxRepository
+	added []model.OfferStatusChanged
+}
+
+func (r *memoryOutboxRepository) Add(_ context.Context, events []model.OfferStatusChanged) error {
+	r.added = append(r.added, events...)
+	return nil
+}
+
+type storedOfferRepository struct {
+	memoryOfferRepository
+	stored []model.Offer
+}
+
+func (r *storedOfferRepository) GetOffers(context.Context, []string) ([]model.Offer, error) {
+	return r.stored, nil
+}
+
+func TestStatusChangeRecorder_Update(t *testing.T) {
+	offers := &storedOfferRepository{stored: []model.Offer{{Code: "A", Status: model.OfferStatusCodeInOrder}}}
+	outbox := &memoryOutboxRepository{}
+	recorder := NewStatusChangeRecorder(offers, outbox)
+
+	err := recorder.Update(context.Background(), []model.Offer{{Code: "A", Status: model.OfferStatusCodeSold}})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if len(outbox.added) != 1 || outbox.added[0].NewStatus != model.OfferStatusCodeSold {
+		t.Errorf("Update() recorded %+v, want one change to sold", outbox.added)
+	}
+	if !reflect.DeepEqual(offers.updated, []string{"A"}) {
+		t.Errorf("Update() updated %v, want [A]", offers.updated)
+	}
+}
@@ -0,0 +1,115 @@
+package upstream
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerHalfOpen
+	breakerOpen
+)
+
+// Breaker rejects calls to the upstream after failureThreshold failed calls in a row. After openTimeout one probe
+// call is let through, its success closes the breaker and its failure opens it again.
+type Breaker struct {
+	upstream         string
+	failureThreshold int
+	openTimeout      time.Duration
+	now              func() time.Time
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func NewBreaker(upstream string, failureThreshold int, openTimeout time.Duration) *Breaker {
+	b := &Breaker{
+		upstream:         upstream,
+		failureThreshold: failureThreshold,
+		openTimeout:      openTimeout,
+		now:              time.Now,
+	}
+	breakerStateGauge.WithLabelValues(upstream).Set(float64(breakerClosed))
+	return b
+}
+
+// allow reports whether the call may go to the upstream, a probe call has to be reported by done.
+func (b *Breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if b.now().Sub(b.openedAt) < b.openTimeout {
+			return false
+		}
+		b.setState(breakerHalfOpen)
+		fallthrough
+	case breakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+	}
+	return true
+}
+
+func (b *Breaker) done(ctx context.Context, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	// the call canceled by the caller or cut by the deadline of the caller tells nothing about the upstream
+	if status.Code(err) == codes.Canceled || ctx.Err() != nil {
+		return
+	}
+	if !IsFailure(err) {
+		b.failures = 0
+		b.setState(breakerClosed)
+		return
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.failureThreshold {
+		b.openedAt = b.now()
+		b.setState(breakerOpen)
+	}
+}
+
+func (b *Breaker) setState(state breakerState) {
+	b.state = state
+	breakerStateGauge.WithLabelValues(b.upstream).Set(float64(state))
+}
+
+// UnaryClientInterceptor fails the calls with Unavailable while the breaker is open.
+func (b *Breaker) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if b.failureThreshold <= 0 {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		if !b.allow() {
+			rejectedRequests.WithLabelValues(b.upstream).Inc()
+			return status.Errorf(codes.Unavailable, "circuit breaker of %s is open", b.upstream)
+		}
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		b.done(ctx, err)
+		return err
+	}
+}
+
+// IsFailure reports whether the error tells about the upstream health, errors of the request itself do not.
+func IsFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Internal, codes.Unknown:
+		return true
+	}
+	return false
+}
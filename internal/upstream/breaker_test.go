@@ -0,0 +1,103 @@
+package upstream
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestBreaker(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "unavailable")
+	notFound := status.Error(codes.NotFound, "not found")
+	canceled := status.Error(codes.Canceled, "canceled")
+	tests := []struct {
+		name string
+		// responses of the upstream to the calls made one by one, nil for success
+		responses []error
+		// elapsed is the time passed since the first call when the checked call is made
+		elapsed   time.Duration
+		wantCalls int
+		wantErr   codes.Code
+	}{
+		{
+			name:      "closed",
+			responses: []error{unavailable, unavailable, nil},
+			wantCalls: 4,
+			wantErr:   codes.OK,
+		},
+		{
+			name:      "request_errors_keep_closed",
+			responses: []error{notFound, notFound, notFound},
+			wantCalls: 4,
+			wantErr:   codes.OK,
+		},
+		{
+			name:      "opened",
+			responses: []error{unavailable, unavailable, unavailable},
+			wantCalls: 3,
+			wantErr:   codes.Unavailable,
+		},
+		{
+			name:      "probe_after_timeout",
+			responses: []error{unavailable, unavailable, unavailable},
+			elapsed:   time.Minute,
+			wantCalls: 4,
+			wantErr:   codes.OK,
+		},
+		{
+			name:      "canceled_calls_are_ignored",
+			responses: []error{unavailable, unavailable, canceled},
+			wantCalls: 4,
+			wantErr:   codes.OK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now()
+			b := NewBreaker("test", 3, 30*time.Second)
+			b.now = func() time.Time { return now }
+			calls := 0
+			invoker := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				calls++
+				if calls <= len(tt.responses) {
+					return tt.responses[calls-1]
+				}
+				return nil
+			}
+			interceptor := b.UnaryClientInterceptor()
+			for range tt.responses {
+				_ = interceptor(context.Background(), "/test", nil, nil, nil, invoker)
+			}
+
+			now = now.Add(tt.elapsed)
+			err := interceptor(context.Background(), "/test", nil, nil, nil, invoker)
+			if status.Code(err) != tt.wantErr {
+				t.Errorf("interceptor() error = %v, want code %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("upstream calls = %v, want %v", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestBreaker_callerDeadline(t *testing.T) {
+	b := NewBreaker("test", 1, time.Minute)
+	interceptor := b.UnaryClientInterceptor()
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	invoker := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return status.FromContextError(ctx.Err()).Err()
+	}
+	_ = interceptor(ctx, "/test", nil, nil, nil, invoker)
+
+	if err := interceptor(context.Background(), "/test", nil, nil, nil, func(context.Context, string, any, any, *grpc.ClientConn, ...grpc.CallOption) error {
+		return nil
+	}); err != nil {
+		t.Errorf("interceptor() error = %v, the expired deadline of the caller opened the breaker", err)
+	}
+}
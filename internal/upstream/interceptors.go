@@ -0,0 +1,98 @@
+package upstream
+
+import (
+	"context"
+	"time"
+
+	grpc_retry "github.com/grpc-ecosystem/go-grpc-middleware/retry"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const retryJitter = 0.2
+
+var (
+	requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "offer_read",
+		Subsystem: "upstream",
+		Name:      "requests_total",
+		Help:      "Number of attempts of the calls to the upstream by the response code.",
+	}, []string{"upstream", "method", "code"})
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "offer_read",
+		Subsystem: "upstream",
+		Name:      "request_duration_seconds",
+		Help:      "Duration of attempts of the calls to the upstream.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 14),
+	}, []string{"upstream", "method"})
+	breakerStateGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "offer_read",
+		Subsystem: "upstream",
+		Name:      "circuit_breaker_state",
+		Help:      "State of the upstream circuit breaker: 0 closed, 1 half-open, 2 open.",
+	}, []string{"upstream"})
+	rejectedRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "offer_read",
+		Subsystem: "upstream",
+		Name:      "rejected_requests_total",
+		Help:      "Number of calls rejected by the open circuit breaker.",
+	}, []string{"upstream"})
+)
+
+type Config struct {
+	// Timeout bounds the call with all its attempts, the earlier deadline of the caller is kept. 0 disables it.
+	Timeout time.Duration
+	// AttemptTimeout bounds one attempt, 0 disables it.
+	AttemptTimeout time.Duration
+	// Retries is the number of repeated attempts on transient codes, 0 disables the retries.
+	Retries uint
+	// RetryBackoff is the base of the exponential delay between the attempts.
+	RetryBackoff time.Duration
+	// BreakerFailures is the number of failed calls in a row which open the circuit breaker, 0 disables it.
+	BreakerFailures int
+	// BreakerOpenTimeout is the time the breaker stays open before a probe call.
+	BreakerOpenTimeout time.Duration
+}
+
+// Interceptors builds the chain for the calls to the upstream: the given interceptors, the circuit breaker,
+// the deadline, the retries and the metrics, which are applied to every attempt. The given interceptors
+// (the rate limiter) wait outside the breaker and the timeouts, so the waiting neither eats the timeouts
+// nor counts as a failure of the upstream. The breaker sees the context of the caller and tells the expired
+// deadline of the caller from the timeouts of the upstream.
+func Interceptors(upstream string, config Config, outer ...grpc.UnaryClientInterceptor) []grpc.UnaryClientInterceptor {
+	return append(append([]grpc.UnaryClientInterceptor{}, outer...),
+		NewBreaker(upstream, config.BreakerFailures, config.BreakerOpenTimeout).UnaryClientInterceptor(),
+		deadline(config.Timeout),
+		grpc_retry.UnaryClientInterceptor(
+			grpc_retry.WithMax(config.Retries),
+			grpc_retry.WithPerRetryTimeout(config.AttemptTimeout),
+			grpc_retry.WithBackoff(grpc_retry.BackoffExponentialWithJitter(config.RetryBackoff, retryJitter)),
+			grpc_retry.WithCodes(codes.Unavailable, codes.ResourceExhausted, codes.Aborted),
+		),
+		metrics(upstream),
+	)
+}
+
+func deadline(timeout time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if timeout <= 0 {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+func metrics(upstream string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		started := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		requestDuration.WithLabelValues(upstream, method).Observe(time.Since(started).Seconds())
+		requests.WithLabelValues(upstream, method, status.Code(err).String()).Inc()
+		return err
+	}
+}